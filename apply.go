@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The actions in a batch edit plan
+const (
+	planAdd     = "add"
+	planRemove  = "remove"
+	planReplace = "replace"
+)
+
+// planEntry is an edit in a batch edit plan, for "addinclude apply"
+type planEntry struct {
+	File   string `yaml:"file"`
+	Action string `yaml:"action"`
+	Header string `yaml:"header"`
+	With   string `yaml:"with"` // the new header, for "replace"
+	Top    bool   `yaml:"top"`
+	NoFix  bool   `yaml:"nofix"`
+	Cpp    bool   `yaml:"c++"`
+}
+
+// Read a batch edit plan, which is a YAML or JSON list of entries with the
+// fields of planEntry. Unknown fields are errors.
+func readPlan(filename string) ([]planEntry, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []planEntry
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return entries, nil
+}
+
+// Check the entries of a plan, and the files they refer to. Returns all errors.
+func validatePlan(entries []planEntry) []error {
+	var errs []error
+	if len(entries) == 0 {
+		errs = append(errs, fmt.Errorf("the plan has no entries"))
+	}
+	for i, entry := range entries {
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("entry %d: %s", i+1, fmt.Sprintf(format, args...)))
+		}
+		switch entry.Action {
+		case planAdd, planRemove, planReplace:
+		case "":
+			fail("missing action")
+		default:
+			fail("unknown action %q, should be add, remove or replace", entry.Action)
+		}
+		if entry.File == "" {
+			fail("missing file")
+		} else if fi, err := os.Stat(entry.File); err != nil || fi.IsDir() {
+			fail("%s is not a file", entry.File)
+		}
+		if entry.Header == "" {
+			fail("missing header")
+		} else if !entry.validHeader(entry.Header) {
+			fail("invalid header %q", entry.Header)
+		}
+		if entry.Action == planReplace && entry.With == "" {
+			fail("missing \"with\", the new header")
+		} else if entry.Action != planReplace && entry.With != "" {
+			fail("\"with\" is only used with replace")
+		} else if entry.With != "" && !entry.validHeader(entry.With) {
+			fail("invalid header %q in \"with\"", entry.With)
+		}
+	}
+	return errs
+}
+
+// Check that the header, like stdio or <stdio.h>, gives an include directive
+func (entry *planEntry) validHeader(header string) bool {
+	directive, err := entry.options().includeDirective(entry.File, header)
+	if err != nil {
+		return false
+	}
+	_, _, ok := parseInclude(directive)
+	return ok
+}
+
+// Return the options for the entry
+func (entry *planEntry) options() *Options {
+	opts := defaultOptions()
+	opts.fixInclude = !entry.NoFix
+	opts.atTop = entry.Top
+	flags, _ := compileFlagsFor(entry.File, "")
+	opts.cppStyle = isCppFile(entry.File, flags) || entry.Cpp
+	opts.useCompileFlags(flags)
+	return opts
+}
+
+// Apply the entry to the text of its file
+func (entry *planEntry) apply(filetext string) (string, error) {
+	opts := entry.options()
+	directive, err := opts.includeDirective(entry.File, entry.Header)
+	if err != nil {
+		return "", err
+	}
+	name, _, _ := parseInclude(directive)
+	switch entry.Action {
+	case planRemove:
+		filetext, n := removeIncludeFromText(filetext, name)
+		if n == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %s does not include %s\n", entry.File, name)
+		}
+		return filetext, nil
+	case planReplace:
+		with, err := opts.includeDirective(entry.File, entry.With)
+		if err != nil {
+			return "", err
+		}
+		filetext, n := replaceIncludeInText(filetext, name, with)
+		if n == 0 {
+			return "", fmt.Errorf("%s does not include %s, which should be replaced", entry.File, name)
+		}
+		return filetext, nil
+	}
+	return addIncludeToText(entry.File, filetext, entry.Header, opts)
+}
+
+// fileChange is the original and the changed contents of a file
+type fileChange struct {
+	filename string
+	mode     os.FileMode
+	original []byte
+	changed  []byte
+}
+
+// Apply all entries of the plan in memory, in order. Files with several
+// entries are changed by each entry in turn.
+func preparePlan(entries []planEntry) ([]*fileChange, error) {
+	var (
+		changes []*fileChange
+		texts   = make(map[string]*encodedText)
+		current = make(map[string]string)
+		byFile  = make(map[string]*fileChange)
+	)
+	for i, entry := range entries {
+		key := filepath.Clean(entry.File)
+		change, ok := byFile[key]
+		if !ok {
+			fi, err := os.Stat(entry.File)
+			if err != nil {
+				return nil, err
+			}
+			data, err := ioutil.ReadFile(entry.File)
+			if err != nil {
+				return nil, err
+			}
+			decoded, err := decodeText(data, encodingAuto)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode %s: %s", entry.File, err)
+			}
+			change = &fileChange{filename: entry.File, mode: fi.Mode(), original: data}
+			changes = append(changes, change)
+			byFile[key], texts[key], current[key] = change, decoded, decoded.text
+		}
+		filetext, err := entry.apply(current[key])
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", i+1, err)
+		}
+		current[key] = filetext
+	}
+	for _, change := range changes {
+		key := filepath.Clean(change.filename)
+		encoded, err := texts[key].encode(current[key])
+		if err != nil {
+			return nil, fmt.Errorf("could not encode %s: %s", change.filename, err)
+		}
+		change.changed = encoded
+	}
+	return changes, nil
+}
+
+// Write all changed files, or none. The new contents are first written to
+// temporary files next to the files, which are then renamed. If a rename
+// fails, the files that were already replaced are restored.
+func commitChanges(changes []*fileChange) error {
+	var temps []string
+	removeTemps := func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}
+	for _, change := range changes {
+		if bytes.Equal(change.original, change.changed) {
+			temps = append(temps, "")
+			continue
+		}
+		f, err := ioutil.TempFile(filepath.Dir(change.filename), "."+filepath.Base(change.filename)+".addinclude-")
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		temps = append(temps, f.Name())
+		_, err = f.Write(change.changed)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(f.Name(), change.mode)
+		}
+		if err != nil {
+			removeTemps()
+			return fmt.Errorf("could not write %s: %s", change.filename, err)
+		}
+	}
+	for i, change := range changes {
+		if temps[i] == "" {
+			continue
+		}
+		if err := os.Rename(temps[i], change.filename); err != nil {
+			// Roll back the files that were already replaced
+			for _, done := range changes[:i] {
+				if !bytes.Equal(done.original, done.changed) {
+					ioutil.WriteFile(done.filename, done.original, done.mode)
+				}
+			}
+			removeTemps()
+			return fmt.Errorf("could not write %s, no files were changed: %s", change.filename, err)
+		}
+	}
+	return nil
+}
+
+// Run "addinclude apply" with the given arguments, and return the exit code
+func runApply(args []string) int {
+	const dryRunText = "check the plan and list the files that would be changed, without changing them"
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: addinclude apply [--dry-run] plan.yaml")
+		fmt.Println()
+		fmt.Println("Apply a batch edit plan. Either all files are changed, or none.")
+		fmt.Println()
+		fmt.Println("\t--dry-run\t\t", dryRunText)
+	}
+	dryRun := fs.Bool("dry-run", false, dryRunText)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Needs a plan file. Use --help for more info.\n")
+		return 1
+	}
+	entries, err := readPlan(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the plan: %s\n", err)
+		return 1
+	}
+	if errs := validatePlan(entries); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", fs.Arg(0), err)
+		}
+		return 1
+	}
+	changes, err := preparePlan(entries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, no files were changed\n", err)
+		return 4
+	}
+	if *dryRun {
+		for _, change := range changes {
+			if !bytes.Equal(change.original, change.changed) {
+				fmt.Println(change.filename)
+			}
+		}
+		return 0
+	}
+	if err := commitChanges(changes); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 2
+	}
+	return 0
+}
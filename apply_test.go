@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func readTestFile(t *testing.T, filename string) string {
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestApplyPlan(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"a.c":   "#include <stdio.h>\n\nint x;\n",
+		"b.cpp": "#include <stdio.h>\n#include <iostream>\n",
+	})
+	a, b := filepath.Join(dir, "a.c"), filepath.Join(dir, "b.cpp")
+	plan := "- file: " + a + "\n  action: add\n  header: string\n" +
+		"- file: " + b + "\n  action: replace\n  header: <stdio.h>\n  with: <cstdio>\n" +
+		"- file: " + b + "\n  action: remove\n  header: iostream\n" +
+		"- file: " + b + "\n  action: add\n  header: vector\n  top: true\n"
+	planFile := filepath.Join(dir, "plan.yaml")
+	assert.Nil(t, os.WriteFile(planFile, []byte(plan), 0644))
+
+	entries, err := readPlan(planFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(entries))
+	assert.Empty(t, validatePlan(entries))
+
+	changes, err := preparePlan(entries)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(changes))
+	assert.Nil(t, commitChanges(changes))
+	assert.Equal(t, "#include <stdio.h>\n#include <string.h>\n\nint x;\n", readTestFile(t, a))
+	assert.Equal(t, "#include <vector>\n#include <cstdio>\n", readTestFile(t, b))
+}
+
+func TestValidatePlan(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"a.c": ""})
+	errs := validatePlan([]planEntry{
+		{File: filepath.Join(dir, "a.c"), Action: "delete", Header: "stdio"},
+		{File: filepath.Join(dir, "missing.c"), Action: planAdd, Header: "stdio"},
+		{File: filepath.Join(dir, "a.c"), Action: planReplace, Header: "stdio"},
+		{File: filepath.Join(dir, "a.c"), Action: planAdd},
+		{File: filepath.Join(dir, "a.c"), Action: planAdd, Header: "a b c"},
+		{File: filepath.Join(dir, "a.c"), Action: planReplace, Header: "stdio", With: "x y z"},
+	})
+	assert.Equal(t, 6, len(errs))
+	assert.Contains(t, errs[0].Error(), "entry 1: unknown action")
+	assert.Contains(t, errs[3].Error(), "entry 4: missing header")
+	assert.Contains(t, errs[4].Error(), "entry 5: invalid header")
+	assert.Contains(t, errs[5].Error(), "entry 6: invalid header \"x y z\" in \"with\"")
+
+	planFile := filepath.Join(dir, "plan.json")
+	assert.Nil(t, os.WriteFile(planFile, []byte(`[{"file": "a.c", "header": "x", "unknown": 1}]`), 0644))
+	_, err := readPlan(planFile)
+	assert.NotNil(t, err)
+
+	// A malformed plan is an error, not a panic
+	assert.Nil(t, os.WriteFile(planFile, []byte("0: [:!00 \xef"), 0644))
+	assert.NotPanics(t, func() {
+		_, err = readPlan(planFile)
+	})
+	assert.NotNil(t, err)
+}
+
+func TestApplyPlanNoChanges(t *testing.T) {
+	// A failing entry means that no files are changed
+	dir := writeTestFiles(t, map[string]string{"a.c": "int x;\n", "b.c": "int y;\n"})
+	a, b := filepath.Join(dir, "a.c"), filepath.Join(dir, "b.c")
+	_, err := preparePlan([]planEntry{
+		{File: a, Action: planAdd, Header: "stdio"},
+		{File: b, Action: planReplace, Header: "stdio", With: "cstdio"},
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "entry 2")
+	assert.Equal(t, "int x;\n", readTestFile(t, a))
+}
+
+func TestCommitChangesRollback(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"a.c": "int x;\n"})
+	a := filepath.Join(dir, "a.c")
+	// Renaming a file over a directory that is not empty fails
+	d := filepath.Join(dir, "d")
+	assert.Nil(t, os.MkdirAll(filepath.Join(d, "sub"), 0755))
+	err := commitChanges([]*fileChange{
+		{filename: a, mode: 0644, original: []byte("int x;\n"), changed: []byte("#include <stdio.h>\nint x;\n")},
+		{filename: d, mode: 0644, original: []byte(""), changed: []byte("x")},
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, "int x;\n", readTestFile(t, a))
+	entries, _ := os.ReadDir(dir)
+	assert.Equal(t, 2, len(entries))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// The output formats for --check
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatSARIF = "sarif"
+)
+
+const (
+	missingIncludeRule = "missing-include"
+	sarifSchema        = "https://json.schemastore.org/sarif-2.1.0.json"
+	projectURL         = "https://github.com/xyproto/addinclude"
+)
+
+// checkResult is the result of --check, for all files
+type checkResult struct {
+	Checked int           `json:"checked"`
+	Missing []*fileReport `json:"missing"`
+}
+
+// Add the report for a checked file. Files that would be changed are missing the include.
+func (result *checkResult) add(report *fileReport) {
+	result.Checked++
+	if report.Action == actionInserted {
+		result.Missing = append(result.Missing, report)
+	}
+}
+
+// Return a description of what is missing in the file
+func (report *fileReport) missingText() string {
+	if report.Header == "" {
+		return "missing lines that addinclude would add"
+	}
+	return "missing #include " + report.Header
+}
+
+// Write the result in the given format: text, json or sarif
+func (result *checkResult) write(w io.Writer, format string) error {
+	switch format {
+	case formatJSON:
+		if result.Missing == nil {
+			result.Missing = []*fileReport{}
+		}
+		return writeIndentedJSON(w, result)
+	case formatSARIF:
+		return writeIndentedJSON(w, sarifLog(result.findings(), map[string]string{
+			missingIncludeRule: "A required include is missing",
+		}))
+	}
+	for _, report := range result.Missing {
+		fmt.Fprintf(w, "%s:%d: %s\n", report.File, report.Line, report.missingText())
+	}
+	return nil
+}
+
+// Write the given value as indented JSON, without escaping < and >
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// finding is a problem at a line in a file, for SARIF output
+type finding struct {
+	file    string
+	line    int
+	rule    string
+	message string
+}
+
+// Return the missing includes as findings
+func (result *checkResult) findings() []finding {
+	var findings []finding
+	for _, report := range result.Missing {
+		findings = append(findings, finding{report.File, report.Line, missingIncludeRule, report.missingText()})
+	}
+	return findings
+}
+
+// Return a SARIF 2.1.0 log, for code scanning dashboards, with the given
+// findings and the given descriptions of the rules, by rule ID
+func sarifLog(findings []finding, rules map[string]string) map[string]interface{} {
+	results := []interface{}{}
+	for _, f := range findings {
+		results = append(results, map[string]interface{}{
+			"ruleId":  f.rule,
+			"level":   "error",
+			"message": map[string]string{"text": f.message},
+			"locations": []interface{}{map[string]interface{}{
+				"physicalLocation": map[string]interface{}{
+					"artifactLocation": map[string]string{"uri": filepath.ToSlash(f.file)},
+					"region":           map[string]int{"startLine": max(f.line, 1)},
+				},
+			}},
+		})
+	}
+	ruleIDs := make([]string, 0, len(rules))
+	for id := range rules {
+		ruleIDs = append(ruleIDs, id)
+	}
+	sort.Strings(ruleIDs)
+	sarifRules := []interface{}{}
+	for _, id := range ruleIDs {
+		sarifRules = append(sarifRules, map[string]interface{}{
+			"id":               id,
+			"shortDescription": map[string]string{"text": rules[id]},
+		})
+	}
+	return map[string]interface{}{
+		"$schema": sarifSchema,
+		"version": "2.1.0",
+		"runs": []interface{}{map[string]interface{}{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "addinclude",
+					"version":        versionString[len("addinclude "):],
+					"informationUri": projectURL,
+					"rules":          sarifRules,
+				},
+			},
+			"results": results,
+		}},
+	}
+}
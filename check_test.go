@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckResult(t *testing.T) {
+	var result checkResult
+	result.add(&fileReport{File: "a.c", Action: actionSkipped, Header: "<stdio.h>", Line: 1})
+	result.add(&fileReport{File: "b.c", Action: actionInserted, Header: "<stdio.h>", Line: 3})
+	assert.Equal(t, 2, result.Checked)
+	assert.Equal(t, 1, len(result.Missing))
+
+	var buf bytes.Buffer
+	assert.Nil(t, result.write(&buf, formatText))
+	assert.Equal(t, "b.c:3: missing #include <stdio.h>\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, result.write(&buf, formatJSON))
+	var decoded checkResult
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, 2, decoded.Checked)
+	assert.Equal(t, "b.c", decoded.Missing[0].File)
+
+	buf.Reset()
+	assert.Nil(t, result.write(&buf, formatSARIF))
+	var sarif struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleID    string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine int }
+					}
+				}
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &sarif))
+	assert.Equal(t, "2.1.0", sarif.Version)
+	assert.Equal(t, missingIncludeRule, sarif.Runs[0].Results[0].RuleID)
+	location := sarif.Runs[0].Results[0].Locations[0].PhysicalLocation
+	assert.Equal(t, "b.c", location.ArtifactLocation.URI)
+	assert.Equal(t, 3, location.Region.StartLine)
+}
+
+func TestCheckNoMissing(t *testing.T) {
+	var (
+		result checkResult
+		buf    bytes.Buffer
+	)
+	assert.Nil(t, result.write(&buf, formatJSON))
+	assert.Contains(t, buf.String(), `"missing": []`)
+}
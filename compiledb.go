@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const compileCommandsFilename = "compile_commands.json"
+
+// cppExtensions are the extensions of C++ source and header files
+var cppExtensions = []string{".cc", ".cpp", ".cxx", ".c++", ".mm", ".hpp", ".hh", ".hxx", ".h++"}
+
+// compileCommand is an entry in compile_commands.json
+type compileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments"`
+}
+
+// compileFlags is the flags from a compile command that matter for adding includes
+type compileFlags struct {
+	compiler    string
+	includeDirs []string // -I
+	systemDirs  []string // -isystem
+	quoteDirs   []string // -iquote
+	defines     []string // -D, as NAME or NAME=VALUE
+	undefines   []string // -U
+	std         string   // -std=, like "c99" or "c++17"
+	language    string   // -x, like "c" or "c++"
+}
+
+// Split a shell command into arguments, with support for quotes and backslashes
+func splitCommand(command string) []string {
+	var (
+		args    []string
+		current strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// Return the arguments of the compile command
+func (cc *compileCommand) args() []string {
+	if len(cc.Arguments) > 0 {
+		return cc.Arguments
+	}
+	return splitCommand(cc.Command)
+}
+
+// Return the absolute path of the file that the command compiles
+func (cc *compileCommand) path() string {
+	path := cc.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cc.Directory, path)
+	}
+	return filepath.Clean(path)
+}
+
+// Parse the flags of the compile command. Relative directories are relative
+// to the directory of the command.
+func (cc *compileCommand) flags() *compileFlags {
+	var (
+		args  = cc.args()
+		flags = &compileFlags{}
+	)
+	dir := func(d string) string {
+		if filepath.IsAbs(d) {
+			return d
+		}
+		return filepath.Join(cc.Directory, d)
+	}
+	if len(args) > 0 {
+		flags.compiler = args[0]
+	}
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		// Return the value of a flag that is given as "-I DIR" or "-IDIR"
+		value := func(flag string) (string, bool) {
+			if arg == flag && i+1 < len(args) {
+				i++
+				return args[i], true
+			} else if strings.HasPrefix(arg, flag) && len(arg) > len(flag) {
+				return arg[len(flag):], true
+			}
+			return "", false
+		}
+		// -isystem and -iquote are checked before -I, which would not match them anyway
+		if v, ok := value("-isystem"); ok {
+			flags.systemDirs = append(flags.systemDirs, dir(v))
+		} else if v, ok := value("-iquote"); ok {
+			flags.quoteDirs = append(flags.quoteDirs, dir(v))
+		} else if v, ok := value("-I"); ok {
+			flags.includeDirs = append(flags.includeDirs, dir(v))
+		} else if v, ok := value("-D"); ok {
+			flags.defines = append(flags.defines, v)
+		} else if v, ok := value("-U"); ok {
+			flags.undefines = append(flags.undefines, v)
+		} else if strings.HasPrefix(arg, "-std=") {
+			flags.std = arg[len("-std="):]
+		} else if v, ok := value("-x"); ok {
+			flags.language = v
+		}
+	}
+	return flags
+}
+
+// Check if the given file is C++, from the compile flags, which may be nil,
+// or else from the extension of the file
+func isCppFile(filename string, flags *compileFlags) bool {
+	if flags != nil {
+		switch {
+		case strings.HasPrefix(flags.language, "c++"):
+			return true
+		case flags.language == "c" || flags.language == "c-header":
+			return false
+		case strings.HasPrefix(flags.std, "c++") || strings.HasPrefix(flags.std, "gnu++"):
+			return true
+		case strings.HasPrefix(flags.std, "c") || strings.HasPrefix(flags.std, "gnu"):
+			return false
+		case strings.HasSuffix(filepath.Base(flags.compiler), "++"):
+			return true
+		}
+	}
+	return hasExtension(filename, cppExtensions)
+}
+
+// compileDB is the entries of a compile_commands.json file
+type compileDB struct {
+	entries []compileCommand
+	err     error // why the file could not be read, if it could not
+}
+
+// Read a compile_commands.json file
+func readCompileDB(filename string) (*compileDB, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	db := new(compileDB)
+	if err := json.Unmarshal(data, &db.entries); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Find the compile command for the given file. Headers are rarely in
+// compile_commands.json, so the command for a source file with the same stem
+// in the same directory, like foo.c for foo.h, is used for them.
+func (db *compileDB) find(filename string) *compileCommand {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	var related *compileCommand
+	for i := range db.entries {
+		cc := &db.entries[i]
+		path := cc.path()
+		if path == abs {
+			return cc
+		}
+		if related == nil && filepath.Dir(path) == filepath.Dir(abs) && stem(path) == stem(abs) {
+			related = cc
+		}
+	}
+	return related
+}
+
+// Find compile_commands.json for the given file, in the directory of the file
+// or a parent directory, or in a "build" directory in one of them
+func findCompileDB(filename string) (string, bool) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", false
+	}
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		for _, path := range []string{filepath.Join(dir, compileCommandsFilename), filepath.Join(dir, "build", compileCommandsFilename)} {
+			if _, err := os.Stat(path); err == nil {
+				return path, true
+			}
+		}
+		if dir == filepath.Dir(dir) {
+			return "", false
+		}
+	}
+}
+
+var (
+	compileDBs      = make(map[string]*compileDB) // read compile_commands.json files, by path
+	compileDBsMutex sync.Mutex
+)
+
+// Find the compile flags for the given file, from the given compile_commands.json
+// file or directory, or from the one that is found for the file if dbPath is "".
+// Returns nil if there are none. A compile_commands.json that is found, but can
+// not be read, is ignored, while one that is given is an error.
+func compileFlagsFor(filename, dbPath string) (*compileFlags, error) {
+	given := dbPath != ""
+	if !given {
+		var ok bool
+		if dbPath, ok = findCompileDB(filename); !ok {
+			return nil, nil
+		}
+	} else if fi, err := os.Stat(dbPath); err == nil && fi.IsDir() {
+		dbPath = filepath.Join(dbPath, compileCommandsFilename)
+	}
+	compileDBsMutex.Lock()
+	defer compileDBsMutex.Unlock()
+	db, ok := compileDBs[dbPath]
+	if !ok {
+		var err error
+		if db, err = readCompileDB(dbPath); err != nil {
+			db = &compileDB{err: fmt.Errorf("%s: %s", dbPath, err)}
+		}
+		compileDBs[dbPath] = db
+	}
+	if db.err != nil && given {
+		return nil, db.err
+	}
+	if cc := db.find(filename); cc != nil {
+		return cc.flags(), nil
+	}
+	return nil, nil
+}
+
+// Use the compile flags for the file: the include directories are searched
+// after the ones that are already given, and the macros are used when finding
+// branches that are never taken, unless they are already given. Flags may be nil.
+func (opts *Options) useCompileFlags(flags *compileFlags) {
+	if flags == nil {
+		return
+	}
+	opts.includeDirs = append(append([]string{}, opts.includeDirs...), flags.includeDirs...)
+	opts.systemDirs = append(append([]string{}, opts.systemDirs...), flags.systemDirs...)
+	opts.quoteDirs = append(append([]string{}, opts.quoteDirs...), flags.quoteDirs...)
+	macros := newMacroSet()
+	for _, spec := range flags.defines {
+		macros.define(spec)
+	}
+	for _, name := range flags.undefines {
+		macros.undefine(name)
+	}
+	if opts.macros != nil {
+		for name, value := range opts.macros.defined {
+			macros.define(name + "=" + value)
+		}
+		for name := range opts.macros.undefined {
+			macros.undefine(name)
+		}
+	}
+	opts.macros = macros
+}
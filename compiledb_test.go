@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	assert.Equal(t, []string{"cc", "-DNAME=\"a b\"", "-I", "my dir", "-c", "x.c"}, splitCommand(`cc -DNAME="\"a b\"" -I 'my dir'  -c x.c`))
+	assert.Equal(t, []string{"cc", "a b"}, splitCommand(`cc a\ b`))
+	assert.Empty(t, splitCommand("  "))
+}
+
+func TestCompileFlags(t *testing.T) {
+	cc := &compileCommand{
+		Directory: "/build",
+		File:      "../src/x.c",
+		Command:   "gcc -Iinclude -I /usr/local/include -isystem sys -iquote q -DDEBUG -D VERSION=3 -UNDEBUG -std=gnu11 -x c -c ../src/x.c",
+	}
+	assert.Equal(t, "/src/x.c", cc.path())
+	flags := cc.flags()
+	assert.Equal(t, "gcc", flags.compiler)
+	assert.Equal(t, []string{"/build/include", "/usr/local/include"}, flags.includeDirs)
+	assert.Equal(t, []string{"/build/sys"}, flags.systemDirs)
+	assert.Equal(t, []string{"/build/q"}, flags.quoteDirs)
+	assert.Equal(t, []string{"DEBUG", "VERSION=3"}, flags.defines)
+	assert.Equal(t, []string{"NDEBUG"}, flags.undefines)
+	assert.Equal(t, "gnu11", flags.std)
+	assert.Equal(t, "c", flags.language)
+}
+
+func TestIsCppFile(t *testing.T) {
+	assert.False(t, isCppFile("x.c", nil))
+	assert.True(t, isCppFile("x.cc", nil))
+	assert.True(t, isCppFile("x.hpp", nil))
+	assert.False(t, isCppFile("x.h", nil))
+	assert.True(t, isCppFile("x.h", &compileFlags{language: "c++"}))
+	assert.True(t, isCppFile("x.c", &compileFlags{std: "c++17"}))
+	assert.False(t, isCppFile("x.cc", &compileFlags{std: "c11"}))
+	assert.True(t, isCppFile("x.h", &compileFlags{compiler: "/usr/bin/g++"}))
+	assert.False(t, isCppFile("x.h", &compileFlags{compiler: "gcc"}))
+}
+
+func TestCompileFlagsFor(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	assert.Nil(t, os.MkdirAll(src, 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "build"), 0755))
+	db := `[
+  {"directory": "` + dir + `/build", "file": "../src/a.c", "arguments": ["g++", "-I../include", "-DUSE_FOO", "-c", "../src/a.c"]}
+]`
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "build", compileCommandsFilename), []byte(db), 0644))
+
+	flags, err := compileFlagsFor(filepath.Join(src, "a.c"), "")
+	assert.Nil(t, err)
+	assert.NotNil(t, flags)
+	assert.Equal(t, []string{filepath.Join(dir, "include")}, flags.includeDirs)
+	assert.True(t, isCppFile(filepath.Join(src, "a.c"), flags))
+
+	// The header uses the command of the source file with the same stem
+	flags, _ = compileFlagsFor(filepath.Join(src, "a.h"), "")
+	assert.NotNil(t, flags)
+	flags, _ = compileFlagsFor(filepath.Join(src, "b.c"), "")
+	assert.Nil(t, flags)
+
+	// The database can also be given as a directory
+	flags, err = compileFlagsFor(filepath.Join(src, "a.c"), filepath.Join(dir, "build"))
+	assert.Nil(t, err)
+	assert.NotNil(t, flags)
+}
+
+func TestCompileDBErrors(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, compileCommandsFilename), []byte("not json"), 0644))
+	filename := filepath.Join(dir, "a.c")
+
+	// A compile_commands.json that is found, but is broken, is ignored
+	flags, err := compileFlagsFor(filename, "")
+	assert.Nil(t, err)
+	assert.Nil(t, flags)
+
+	// ...but one that is given is an error, also when it is missing
+	_, err = compileFlagsFor(filename, dir)
+	assert.NotNil(t, err)
+	_, err = compileFlagsFor(filename, filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestUseCompileFlags(t *testing.T) {
+	opts := defaultOptions()
+	opts.includeDirs = []string{"cli"}
+	opts.macros.define("VERSION=4")
+	opts.useCompileFlags(&compileFlags{
+		includeDirs: []string{"db"},
+		defines:     []string{"USE_FOO", "VERSION=3"},
+		undefines:   []string{"NDEBUG"},
+	})
+	assert.Equal(t, []string{"cli", "db"}, opts.includeDirs)
+	assert.Equal(t, alwaysTrue, opts.macros.eval("defined(USE_FOO) && VERSION == 4"))
+	assert.Equal(t, alwaysFalse, opts.macros.eval("defined(NDEBUG)"))
+
+	// The branch that is never taken is skipped
+	const filetext = "#ifdef USE_FOO\n#include <foo.h>\n#else\n#include <bar.h>\n#endif\n"
+	result, err := addIncludeToText("x.c", filetext, "<baz.h>", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#ifdef USE_FOO\n#include <foo.h>\n#include <baz.h>\n#else\n#include <bar.h>\n#endif\n", result)
+}
@@ -0,0 +1,356 @@
+package main
+
+import "strings"
+
+// condBranch is one branch of a conditional, like the #ifdef, #elif or #else part
+type condBranch struct {
+	directive string // "if", "ifdef", "ifndef", "elif", "else" and so on
+	condition string // the condition as written, without comments
+	start     int    // the index of the line with the directive
+	last      int    // the index of the last line of the directive, if it is continued with \
+	end       int    // the index of the line with the next #elif, #else or #endif
+	region    *condRegion
+	children  []*condRegion
+	dead      bool // set by evaluate, for branches that are never taken
+}
+
+// condRegion is a conditional, from the #if, #ifdef or #ifndef line to the #endif line
+type condRegion struct {
+	branches []*condBranch
+	start    int // the index of the line with the #if, #ifdef or #ifndef
+	end      int // the index of the line with the #endif, or -1 if it is missing
+	parent   *condBranch
+}
+
+// condTree is all the conditional regions in a file
+type condTree struct {
+	regions []*condRegion // the top level regions
+	guard   *condRegion   // the include guard, if there is one
+	lines   []line
+}
+
+// Join a line that is continued with backslashes with the following lines.
+// Returns the joined text and the index of the last line.
+func joinContinued(lines []line, i int) (string, int) {
+	text := lines[i].text
+	for strings.HasSuffix(text, "\\") && i+1 < len(lines) {
+		i++
+		text = text[:len(text)-1] + lines[i].text
+	}
+	return text, i
+}
+
+// Remove // and /* */ comments from the given line, and collapse whitespace
+func stripComments(text string) string {
+	for {
+		start := strings.Index(text, "/*")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(text[start+2:], "*/")
+		if end == -1 {
+			text = text[:start]
+			break
+		}
+		text = text[:start] + " " + text[start+2+end+2:]
+	}
+	if pos := strings.Index(text, "//"); pos != -1 {
+		text = text[:pos]
+	}
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Build a tree of the conditional regions in the given lines
+func parseConditionals(lines []line) *condTree {
+	var (
+		tree  = &condTree{lines: lines}
+		stack []*condRegion
+	)
+	for i := 0; i < len(lines); i++ {
+		text, last := joinContinued(lines, i)
+		_, name, rest, _ := parseDirective(text)
+		condition := stripComments(rest)
+		switch name {
+		case "if", "ifdef", "ifndef":
+			region := &condRegion{start: i, end: -1}
+			region.branches = []*condBranch{{name, condition, i, last, len(lines), region, nil, false}}
+			if len(stack) == 0 {
+				tree.regions = append(tree.regions, region)
+			} else {
+				parent := stack[len(stack)-1].lastBranch()
+				parent.children = append(parent.children, region)
+				region.parent = parent
+			}
+			stack = append(stack, region)
+		case "elif", "elifdef", "elifndef", "else":
+			if len(stack) == 0 {
+				break
+			}
+			region := stack[len(stack)-1]
+			region.lastBranch().end = i
+			if name == "else" {
+				condition = ""
+			}
+			region.branches = append(region.branches, &condBranch{name, condition, i, last, len(lines), region, nil, false})
+		case "endif":
+			if len(stack) == 0 {
+				break
+			}
+			region := stack[len(stack)-1]
+			region.lastBranch().end = i
+			region.end = i
+			stack = stack[:len(stack)-1]
+		}
+		i = last
+	}
+	tree.guard = tree.findGuard()
+	return tree
+}
+
+func (region *condRegion) lastBranch() *condBranch {
+	return region.branches[len(region.branches)-1]
+}
+
+// Return the condition as an expression, where "#ifdef X" is "defined(X)"
+// and "#ifndef X" is "!defined(X)". The expression for #else is "".
+func (branch *condBranch) expr() string {
+	switch branch.directive {
+	case "ifdef", "elifdef":
+		return "defined(" + branch.condition + ")"
+	case "ifndef", "elifndef":
+		return "!defined(" + branch.condition + ")"
+	}
+	return branch.condition
+}
+
+// Check if the line is blank or only has a comment. inComment is updated when
+// a block comment starts or ends on the line.
+func isBlankOrComment(text string, inComment *bool) bool {
+	trimmed := strings.TrimSpace(text)
+	for trimmed != "" {
+		if *inComment {
+			end := strings.Index(trimmed, "*/")
+			if end == -1 {
+				return true
+			}
+			*inComment = false
+			trimmed = strings.TrimSpace(trimmed[end+2:])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(trimmed, "//"):
+			return true
+		case strings.HasPrefix(trimmed, "/*"):
+			*inComment = true
+			trimmed = trimmed[2:]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Find the include guard: an #ifndef X and #define X pair that has only
+// blank lines and comments before it and after the #endif
+func (tree *condTree) findGuard() *condRegion {
+	if len(tree.regions) == 0 {
+		return nil
+	}
+	region := tree.regions[0]
+	first := region.branches[0]
+	if first.directive != "ifndef" || len(region.branches) != 1 || region.end == -1 {
+		return nil
+	}
+	inComment := false
+	for i := 0; i < region.start; i++ {
+		if !isBlankOrComment(tree.lines[i].text, &inComment) {
+			return nil
+		}
+	}
+	// The next directive must be #define with the same name
+	for i := first.last + 1; i < region.end; i++ {
+		if isBlankOrComment(tree.lines[i].text, &inComment) {
+			continue
+		}
+		_, name, rest, _ := parseDirective(tree.lines[i].text)
+		fields := strings.Fields(rest)
+		if name != "define" || len(fields) == 0 || fields[0] != first.condition {
+			return nil
+		}
+		break
+	}
+	inComment = false
+	for i := region.end + 1; i < len(tree.lines); i++ {
+		if !isBlankOrComment(tree.lines[i].text, &inComment) {
+			return nil
+		}
+	}
+	return region
+}
+
+// Find the innermost branch that an insertion before the line at the given
+// index would end up in. Returns nil for the top level.
+func (tree *condTree) branchAt(index int) *condBranch {
+	var found *condBranch
+	regions := tree.regions
+	for len(regions) > 0 {
+		var next []*condRegion
+		for _, region := range regions {
+			for _, branch := range region.branches {
+				if branch.last < index && index <= branch.end {
+					found = branch
+					next = branch.children
+				}
+			}
+		}
+		regions = next
+	}
+	return found
+}
+
+// Find the outermost region that contains an insertion before the line at the
+// given index, not counting the include guard. Returns nil for the top level.
+func (tree *condTree) outermostAt(index int, skipGuard bool) *condRegion {
+	var outermost *condRegion
+	for branch := tree.branchAt(index); branch != nil; branch = branch.region.parent {
+		if skipGuard && branch.region == tree.guard {
+			break
+		}
+		outermost = branch.region
+	}
+	return outermost
+}
+
+// Check if an insertion before the line at the given index is at the top level,
+// where the inside of the include guard also counts as the top level
+func (tree *condTree) isTopLevel(index int) bool {
+	branch := tree.branchAt(index)
+	return branch == nil || branch.region == tree.guard
+}
+
+// Check if an insertion before the line at the given index would split a line
+// that is continued with a backslash, or a directive from its continuation lines
+func (tree *condTree) splitsLine(index int) bool {
+	return index > 0 && index < len(tree.lines) && strings.HasSuffix(tree.lines[index-1].text, "\\")
+}
+
+// Find the branches that are never taken, like #if 0, given the macros that are
+// known to be defined or undefined. Returns which lines are in dead code. For
+// regions where all branches are dead, the directive lines are included.
+func (tree *condTree) evaluate(macros *macroSet) []bool {
+	dead := make([]bool, len(tree.lines))
+	mark := func(from, to int) {
+		for i := from; i < to && i < len(dead); i++ {
+			dead[i] = true
+		}
+	}
+	var walk func(regions []*condRegion, parentDead bool)
+	walk = func(regions []*condRegion, parentDead bool) {
+		for _, region := range regions {
+			taken, allDead := false, true
+			for _, branch := range region.branches {
+				value := unknown
+				if branch.directive != "else" {
+					value = macros.eval(branch.expr())
+				}
+				branch.dead = parentDead || taken || value == alwaysFalse
+				if value == alwaysTrue {
+					taken = true
+				}
+				if branch.dead {
+					mark(branch.last+1, branch.end)
+				} else {
+					allDead = false
+				}
+				walk(branch.children, branch.dead)
+			}
+			if allDead {
+				end := region.end
+				if end == -1 {
+					end = len(dead) - 1
+				}
+				mark(region.start, end+1)
+			}
+		}
+	}
+	walk(tree.regions, false)
+	return dead
+}
+
+// Move an insertion line index out of dead branches, to the start of the next
+// branch that may be taken, or to after the #endif. Returns false if there is
+// no such place.
+func (tree *condTree) liveIndex(index int) (int, bool) {
+	branch := tree.branchAt(index)
+	if branch == nil || !branch.dead {
+		return index, true
+	}
+	// Find the outermost dead branch
+	for branch.region.parent != nil && branch.region.parent.dead {
+		branch = branch.region.parent
+	}
+	region := branch.region
+	for i, b := range region.branches {
+		if b == branch {
+			for _, later := range region.branches[i+1:] {
+				if !later.dead {
+					return later.last + 1, true
+				}
+			}
+		}
+	}
+	if region.end == -1 {
+		return index, false
+	}
+	return region.end + 1, true
+}
+
+// Normalize a condition for comparison, so that "defined X", "defined( X )"
+// and "defined(X)" are the same
+func normalizeCondition(condition string) string {
+	tokens := tokenize(condition)
+	var sb strings.Builder
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "defined" && i+1 < len(tokens) && tokens[i+1] != "(" {
+			sb.WriteString("defined(" + tokens[i+1] + ")")
+			i++
+			continue
+		}
+		sb.WriteString(tokens[i])
+	}
+	return sb.String()
+}
+
+// Check if the condition of the branch matches the given condition.
+// A macro name also matches "defined(NAME)".
+func (branch *condBranch) matches(condition string) bool {
+	expr := normalizeCondition(branch.expr())
+	return expr == normalizeCondition(condition) || expr == normalizeCondition("defined("+condition+")")
+}
+
+// Find the branch given by a selector, like "defined(_WIN32)" for the branch
+// with that condition, or "else:HAVE_FOO" for the #else branch of the
+// conditional that starts with "#if HAVE_FOO" or "#ifdef HAVE_FOO".
+// Returns nil if there is no such branch.
+func (tree *condTree) findBranch(selector string) *condBranch {
+	isElse := strings.HasPrefix(selector, "else:")
+	condition := strings.TrimPrefix(selector, "else:")
+	var find func(regions []*condRegion) *condBranch
+	find = func(regions []*condRegion) *condBranch {
+		for _, region := range regions {
+			for _, branch := range region.branches {
+				switch {
+				case isElse && branch.directive == "else" && region.branches[0].matches(condition):
+					return branch
+				case !isElse && branch.directive != "else" && branch.matches(condition):
+					return branch
+				}
+				if found := find(branch.children); found != nil {
+					return found
+				}
+			}
+		}
+		return nil
+	}
+	return find(tree.regions)
+}
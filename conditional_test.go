@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const conditionalTestContent = `/* guarded */
+#ifndef FOO_H
+#define FOO_H
+
+#include <a.h>
+
+#if defined(_WIN32) && \
+    !defined(NO_WIN)
+#include <windows.h>
+#elif 0
+#include <old.h>
+#else
+#ifdef HAVE_B
+#include <b.h>
+#endif
+#endif
+
+#endif /* FOO_H */
+`
+
+func TestParseConditionals(t *testing.T) {
+	tree := parseConditionals(splitLines(conditionalTestContent))
+	assert.Len(t, tree.regions, 1)
+	assert.Equal(t, tree.regions[0], tree.guard)
+	guard := tree.guard
+	assert.Equal(t, 1, guard.start)
+	assert.Equal(t, 17, guard.end)
+	assert.Equal(t, "!defined(FOO_H)", guard.branches[0].expr())
+
+	platform := guard.branches[0].children[0]
+	assert.Len(t, platform.branches, 3)
+	assert.Equal(t, "defined(_WIN32) && !defined(NO_WIN)", platform.branches[0].expr())
+	assert.Equal(t, 7, platform.branches[0].last)
+	assert.Equal(t, "0", platform.branches[1].expr())
+	assert.Equal(t, "else", platform.branches[2].directive)
+	assert.Equal(t, "defined(HAVE_B)", platform.branches[2].children[0].branches[0].expr())
+
+	assert.Nil(t, tree.branchAt(0))
+	assert.Equal(t, guard.branches[0], tree.branchAt(5))
+	assert.True(t, tree.isTopLevel(5))
+	assert.False(t, tree.isTopLevel(9))
+	assert.Equal(t, platform.branches[2], tree.branchAt(12))
+	assert.Equal(t, platform, tree.outermostAt(14, true))
+	assert.Equal(t, guard, tree.outermostAt(14, false))
+	assert.True(t, tree.splitsLine(7))
+	assert.Nil(t, parseConditionals(splitLines("int x;\n#ifndef A\n#define A\n#endif\n")).guard)
+}
+
+func TestPlacementScope(t *testing.T) {
+	source := newSourceCode(conditionalTestContent)
+	assert.Equal(t, 12, source.inScope(12, scopeAuto))
+	assert.Equal(t, 5, source.inScope(12, scopeTop))
+	assert.Equal(t, 1, source.inScope(12, scopeOutside))
+	// Continued lines are never split
+	assert.Equal(t, 8, source.inScope(7, scopeAuto))
+
+	source = newSourceCode("#if defined(A)\n#include <a.h>\n#endif\n")
+	index, err := source.findPlacement("x.h", &Options{scope: scopeAuto})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, index)
+	index, err = source.findPlacement("x.h", &Options{scope: scopeTop})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, index)
+}
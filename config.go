@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const configFilename = ".addinclude.conf"
+
+// iniSection is a [name] section in an INI-style file, like .editorconfig
+type iniSection struct {
+	name       string
+	properties map[string]string
+}
+
+// Read an INI-style file. Returns the properties before the first section,
+// and the sections. Keys are lowercase. Lines starting with # or ; are comments.
+func readINI(filename string) (map[string]string, []iniSection, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var (
+		preamble = make(map[string]string)
+		sections []iniSection
+		scanner  = bufio.NewScanner(f)
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			sections = append(sections, iniSection{line[1 : len(line)-1], make(map[string]string)})
+			continue
+		}
+		pos := strings.IndexAny(line, "=:")
+		if pos == -1 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:pos]))
+		value := strings.TrimSpace(line[pos+1:])
+		if len(sections) == 0 {
+			preamble[key] = value
+		} else {
+			sections[len(sections)-1].properties[key] = value
+		}
+	}
+	return preamble, sections, scanner.Err()
+}
+
+// Config is the settings from the .addinclude.conf files in the directory of
+// the edited file and its parent directories, and from the user configuration
+type Config struct {
+	platformSets []platformSet
+}
+
+// Find the configuration files for the given file, from the closest one to the
+// user configuration file in ~/.config/addinclude/config
+func configFiles(filename string) []string {
+	var found []string
+	if abs, err := filepath.Abs(filename); err == nil {
+		for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+			configFile := filepath.Join(dir, configFilename)
+			if _, err := os.Stat(configFile); err == nil {
+				found = append(found, configFile)
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		configFile := filepath.Join(dir, "addinclude", "config")
+		if _, err := os.Stat(configFile); err == nil {
+			found = append(found, configFile)
+		}
+	}
+	return found
+}
+
+// Read the configuration for the given file. Settings in closer files take
+// precedence, and the built-in platform sets come last.
+func readConfig(filename string) *Config {
+	config := new(Config)
+	for _, configFile := range configFiles(filename) {
+		_, sections, err := readINI(configFile)
+		if err != nil {
+			continue
+		}
+		for _, section := range sections {
+			fields := strings.Fields(section.name)
+			if len(fields) == 2 && fields[0] == "platform" {
+				config.platformSets = append(config.platformSets, platformSet{
+					name:    fields[1],
+					posix:   strings.Fields(section.properties["posix"]),
+					windows: strings.Fields(section.properties["windows"]),
+				})
+			}
+		}
+	}
+	config.platformSets = append(config.platformSets, builtinPlatformSets...)
+	return config
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Move a byte offset in the original text so that it stays on the same
+// character after the given edits
+func adjustCursor(before string, edits []textEdit, cursor int) int {
+	delta := 0
+	for _, edit := range edits {
+		end := edit.Offset + edit.Length
+		switch {
+		case end < cursor || (edit.Length == 0 && edit.Offset == cursor):
+			// The edit is before the cursor. Text that is inserted at the cursor
+			// moves the character at the cursor.
+			delta += len(edit.Text) - edit.Length
+		case edit.Offset < cursor:
+			// The cursor is within the replaced text. The replaced lines start
+			// and end the same way, except for what was inserted or removed.
+			old := before[edit.Offset:end]
+			prefix := 0
+			for prefix < len(old) && prefix < len(edit.Text) && old[prefix] == edit.Text[prefix] {
+				prefix++
+			}
+			if cursor-edit.Offset > prefix {
+				delta += max(len(edit.Text)-edit.Length, edit.Offset+prefix-cursor)
+			}
+		}
+	}
+	return cursor + delta
+}
+
+// Add the include to the text of an editor buffer, and return the new text and
+// the cursor offset, moved so that it stays on the same character. Offsets are
+// in bytes.
+func addIncludeToBuffer(filename, text, include string, cursor int, opts *Options) (string, int, error) {
+	if cursor < 0 || cursor > len(text) {
+		return "", 0, fmt.Errorf("the cursor offset %d is outside of the text", cursor)
+	}
+	changed, err := addIncludeToText(filename, text, include, opts)
+	if err != nil {
+		return "", 0, err
+	}
+	return changed, adjustCursor(text, computeEdits(text, changed), cursor), nil
+}
+
+// Add the include to the text of an editor buffer, in the same way as
+// addIncludeToBuffer, but with the cursor given as line and column. Lines and
+// columns count from 1, and columns are counted in characters.
+func addIncludeToBufferAt(filename, text, include string, line, column int, opts *Options) (string, int, int, error) {
+	cursor, err := lineColumnToOffset(text, line, column)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	changed, cursor, err := addIncludeToBuffer(filename, text, include, cursor, opts)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	line, column = offsetToLineColumn(changed, cursor)
+	return changed, line, column, nil
+}
+
+// Convert a line and a column, counting from 1 and with the column counted
+// in characters, to a byte offset in the text
+func lineColumnToOffset(text string, line, column int) (int, error) {
+	lines := splitLines(text)
+	if line < 1 || column < 1 || line > max(len(lines), 1) {
+		return 0, fmt.Errorf("%d:%d is outside of the text", line, column)
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+	offset := lineOffsets(lines)[line-1]
+	l := lines[line-1].text
+	for i := 1; i < column; i++ {
+		if l == "" {
+			return 0, fmt.Errorf("%d:%d is outside of the line", line, column)
+		}
+		_, size := utf8.DecodeRuneInString(l)
+		offset += size
+		l = l[size:]
+	}
+	return offset, nil
+}
+
+// Convert a byte offset in the text to a line and a column, counting from 1
+// and with the column counted in characters
+func offsetToLineColumn(text string, offset int) (int, int) {
+	lines := splitLines(text)
+	offsets := lineOffsets(lines)
+	for i := range lines {
+		if offset < offsets[i+1] || i == len(lines)-1 {
+			column := min(offset-offsets[i], len(lines[i].text))
+			return i + 1, utf8.RuneCountInString(lines[i].text[:column]) + 1
+		}
+	}
+	return 1, 1
+}
+
+// cursorPosition is a cursor given with --cursor, as a byte offset or as LINE:COLUMN
+type cursorPosition struct {
+	offset       int
+	line, column int
+	isLineColumn bool
+}
+
+// Parse a cursor position, like "120" or "12:5"
+func parseCursor(s string) (cursorPosition, error) {
+	if pos := strings.Index(s, ":"); pos != -1 {
+		line, err1 := strconv.Atoi(s[:pos])
+		column, err2 := strconv.Atoi(s[pos+1:])
+		if err1 != nil || err2 != nil {
+			return cursorPosition{}, fmt.Errorf("invalid cursor position: %s", s)
+		}
+		return cursorPosition{line: line, column: column, isLineColumn: true}, nil
+	}
+	offset, err := strconv.Atoi(s)
+	if err != nil {
+		return cursorPosition{}, fmt.Errorf("invalid cursor position: %s", s)
+	}
+	return cursorPosition{offset: offset}, nil
+}
+
+// bufferResult is the output of --cursor with --json
+type bufferResult struct {
+	Text   string `json:"text"`
+	Cursor int    `json:"cursor"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// Add the include to the buffer that is read from in, as for the given file,
+// and write the new buffer to out. The cursor is a byte offset in the buffer,
+// or a line and a column. The moved cursor is written to cursorOut, in the
+// same form. With jsonOutput, the buffer and the cursor are written to out as
+// JSON instead, and the explanation, with opts.explain, to cursorOut.
+func editBuffer(filename, include string, c cursorPosition, opts *Options, jsonOutput bool, in io.Reader, out, cursorOut io.Writer) error {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	decoded, err := decodeText(data, opts.encoding)
+	if err != nil {
+		return err
+	}
+	text := decoded.text
+	if !c.isLineColumn {
+		// Convert the byte offset in the buffer to an offset in the decoded text
+		if c.offset < len(decoded.bom) || c.offset > len(data) {
+			return fmt.Errorf("the cursor offset %d is outside of the text", c.offset)
+		}
+		prefix, err := decodeText(data[len(decoded.bom):c.offset], decoded.encoding)
+		if err != nil {
+			return err
+		}
+		c.offset = len(prefix.text)
+	} else if c.offset, err = lineColumnToOffset(text, c.line, c.column); err != nil {
+		return err
+	}
+	changed, e, err := addIncludeExplained(filename, text, include, opts)
+	if err != nil {
+		return err
+	}
+	cursor := adjustCursor(text, computeEdits(text, changed), c.offset)
+	encoded, err := decoded.encode(changed)
+	if err != nil {
+		return err
+	}
+	c.offset = decoded.byteOffset(changed, cursor)
+	c.line, c.column = offsetToLineColumn(changed, cursor)
+	if jsonOutput {
+		if e != nil {
+			// The cursor is in the JSON output, so the explanation goes to cursorOut
+			if err := e.write(cursorOut); err != nil {
+				return err
+			}
+		}
+		return writeIndentedJSON(out, bufferResult{changed, c.offset, c.line, c.column})
+	}
+	if _, err := out.Write(encoded); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(cursorOut, c)
+	return err
+}
+
+func (c cursorPosition) String() string {
+	if c.isLineColumn {
+		return fmt.Sprintf("%d:%d", c.line, c.column)
+	}
+	return strconv.Itoa(c.offset)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestAddIncludeToBuffer(t *testing.T) {
+	text := "#include <string.h>\n\nint main() {\n  return 0;\n}\n"
+	cursor := strings.Index(text, "return")
+	changed, moved, err := addIncludeToBuffer("x.c", text, "stdio", cursor, defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, "return", changed[moved:moved+6])
+
+	// The cursor is above the include
+	changed, moved, err = addIncludeToBuffer("x.c", text, "stdio", 3, defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, moved)
+
+	// The cursor is at the end of the last line, which has no newline
+	changed, moved, err = addIncludeToBuffer("x.c", "#include <a.h>", "stdio", 14, defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, "#include <a.h>\n#include <stdio.h>", changed)
+	assert.Equal(t, 14, moved)
+
+	_, _, err = addIncludeToBuffer("x.c", text, "stdio", len(text)+1, defaultOptions())
+	assert.NotNil(t, err)
+}
+
+func TestAdjustCursor(t *testing.T) {
+	before := "a\n#include <b.h>\nc\n"
+	// The line with the cursor is removed
+	assert.Equal(t, 2, adjustCursor(before, []textEdit{{Line: 2, Offset: 2, Length: 15}}, 5))
+	// Text inserted at the cursor moves it
+	assert.Equal(t, 6, adjustCursor(before, []textEdit{{Line: 2, Offset: 2, Text: "x\ny\n"}}, 2))
+}
+
+func TestAddIncludeToBufferAt(t *testing.T) {
+	text := "#include <string.h>\n\nint main() {\n  return 0; // æøå\n}\n"
+	changed, line, column, err := addIncludeToBufferAt("x.c", text, "stdio", 4, 16, defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, line)
+	assert.Equal(t, 16, column)
+	offset, err := lineColumnToOffset(changed, line, column)
+	assert.Nil(t, err)
+	assert.Equal(t, "æø", changed[offset:offset+4])
+
+	_, err = lineColumnToOffset(text, 9, 1)
+	assert.NotNil(t, err)
+}
+
+func TestParseCursor(t *testing.T) {
+	c, err := parseCursor("120")
+	assert.Nil(t, err)
+	assert.Equal(t, cursorPosition{offset: 120}, c)
+	c, err = parseCursor("12:5")
+	assert.Nil(t, err)
+	assert.Equal(t, "12:5", c.String())
+	_, err = parseCursor("x:5")
+	assert.NotNil(t, err)
+}
+
+func TestEditBuffer(t *testing.T) {
+	var out, cursorOut bytes.Buffer
+	in := strings.NewReader("\xef\xbb\xbfint x;\n")
+	c := cursorPosition{offset: 7}
+	assert.Nil(t, editBuffer("x.c", "stdio", c, defaultOptions(), false, in, &out, &cursorOut))
+	assert.Equal(t, "\xef\xbb\xbf#include <stdio.h>\n\nint x;\n", out.String())
+	assert.Equal(t, "27\n", cursorOut.String())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Split a NAME or NAME=VALUE macro definition into the name and the value
+func splitDefine(spec string) (string, string) {
+	if pos := strings.Index(spec, "="); pos != -1 {
+		return spec[:pos], spec[pos+1:]
+	}
+	return spec, ""
+}
+
+// Find the line index and the value of the first #define of the given macro,
+// or -1 if it is not defined
+func (src *SourceCode) findDefine(name string) (int, string) {
+	for i := range src.lines {
+		text, _ := joinContinued(src.lines, i)
+		if _, directive, rest, _ := parseDirective(text); directive == "define" {
+			rest = strings.TrimSpace(rest)
+			if macro := strings.Fields(rest); len(macro) > 0 && macro[0] == name {
+				return i, stripComments(rest[len(name):])
+			}
+		}
+	}
+	return -1, ""
+}
+
+// Find the line index for feature test macros, like _GNU_SOURCE, which must be
+// defined before the first #include. They are placed after the include guard
+// and after the leading comments. If the first #include is in a conditional,
+// they are placed above the conditional, so that they are always defined.
+func (src *SourceCode) defineIndex() int {
+	tree := parseConditionals(src.lines)
+	lowest := src.preambleEnd()
+	if tree.guard != nil {
+		// Skip the #ifndef and #define lines of the include guard
+		guardDefine := tree.guard.branches[0].last + 1
+		for guardDefine < len(src.lines) && directiveName(src.lines[guardDefine].text) != "define" {
+			guardDefine++
+		}
+		lowest = guardDefine + 1
+	}
+	if includes := src.includeLines(); len(includes) > 0 {
+		if first := src.lineIndex(includes[0].start); first >= lowest {
+			if region := tree.outermostAt(first, true); region != nil && region.start >= lowest {
+				return region.start
+			}
+			return first
+		}
+	}
+	return lowest
+}
+
+// Add "#define NAME VALUE" for a NAME or NAME=VALUE string, before the first
+// include. Nothing is added if the macro is already defined.
+func addDefine(filename, filetext, spec string, opts *Options) (string, error) {
+	name, value := splitDefine(spec)
+	source := newSourceCode(filetext)
+	live := source.live(opts)
+	reachable := source.reachable(opts)
+
+	if index, existing := reachable.findDefine(name); index != -1 {
+		if existing != value && value != "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s is already defined as %q in %s\n", name, existing, filename)
+		}
+		for _, include := range reachable.includeLines() {
+			if reachable.lineIndex(include.start) < index {
+				fmt.Fprintf(os.Stderr, "Warning: %s is defined after the include of %s in %s, and may have no effect\n", name, include.name, filename)
+				break
+			}
+		}
+		return filetext, nil
+	}
+
+	directive := "#define " + name
+	if value != "" {
+		directive += " " + value
+	}
+	index, ok := source.unprotectedIndex(live.defineIndex())
+	if !ok {
+		return "", fmt.Errorf("%s can only be defined in a protected region of %s", name, filename)
+	}
+	return joinLines(insertLine(source.lines, index, directive, source.getNewline())), nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAddDefine(t *testing.T) {
+	testcontent := `/* SPDX-License-Identifier: GPL-2.0 */
+#ifndef X_H
+#define X_H
+
+#include <stdio.h>
+
+#endif
+`
+	text, err := addDefine("x.h", testcontent, "_POSIX_C_SOURCE=200809L", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, `/* SPDX-License-Identifier: GPL-2.0 */
+#ifndef X_H
+#define X_H
+
+#define _POSIX_C_SOURCE 200809L
+#include <stdio.h>
+
+#endif
+`, text)
+	// Existing definitions are not added again
+	again, err := addDefine("x.h", text, "_POSIX_C_SOURCE", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, text, again)
+}
+
+func TestDefineBeforeConditionalInclude(t *testing.T) {
+	// The macro is defined above the conditional, and not only when HAVE_X is defined
+	text, err := addDefine("x.c", "#ifdef HAVE_X\n#include <x.h>\n#endif\n#include <stdio.h>\n", "_GNU_SOURCE", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, "#define _GNU_SOURCE\n#ifdef HAVE_X\n#include <x.h>\n#endif\n#include <stdio.h>\n", text)
+	// ...but inside the include guard
+	text, err = addDefine("x.h", "#ifndef X_H\n#define X_H\n#if A\n#if B\n#include <x.h>\n#endif\n#endif\n#endif\n", "_GNU_SOURCE", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, "#ifndef X_H\n#define X_H\n\n#define _GNU_SOURCE\n#if A\n#if B\n#include <x.h>\n#endif\n#endif\n#endif\n", text)
+}
+
+func TestDefineIndex(t *testing.T) {
+	assert.Equal(t, 2, newSourceCode("// comment\n// more\n\nint x;\n").defineIndex())
+	assert.Equal(t, 0, newSourceCode("int x;\n").defineIndex())
+	assert.Equal(t, 2, newSourceCode("#ifndef A\n#define A\n\n#endif\n").defineIndex())
+	index, value := newSourceCode("#define  WIN32_LEAN_AND_MEAN 1 // lean\n").findDefine("WIN32_LEAN_AND_MEAN")
+	assert.Equal(t, 0, index)
+	assert.Equal(t, "1", value)
+}
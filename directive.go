@@ -0,0 +1,121 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// directiveRegexp matches a preprocessor directive the way the C preprocessor
+// does, with optional whitespace before and after the "#"
+var directiveRegexp = regexp.MustCompile(`^([ \t]*#[ \t]*)([A-Za-z_]\w*)`)
+
+// Parse a line as a preprocessor directive. Returns the prefix (the indentation,
+// the "#" and any whitespace after it), the directive name, such as "include",
+// and the rest of the line.
+func parseDirective(text string) (prefix, name, rest string, ok bool) {
+	m := directiveRegexp.FindStringSubmatchIndex(text)
+	if m == nil {
+		return "", "", "", false
+	}
+	return text[m[2]:m[3]], text[m[4]:m[5]], text[m[5]:], true
+}
+
+// Return the name of the directive on the given line, or "" if it is not a directive
+func directiveName(text string) string {
+	_, name, _, _ := parseDirective(text)
+	return name
+}
+
+// Parse the header name from an #include directive, for instance "stdio.h"
+// from "#  include <stdio.h>". quoted is true if it was within quotes.
+func parseInclude(text string) (name string, quoted, ok bool) {
+	_, directive, rest, ok := parseDirective(text)
+	if !ok || directive != "include" {
+		return "", false, false
+	}
+	rest = strings.TrimSpace(rest)
+	if len(rest) > 2 && (rest[0] == '"' || rest[0] == '<') {
+		closing := "\""
+		if rest[0] == '<' {
+			closing = ">"
+		}
+		if pos := strings.Index(rest[1:], closing); pos > 0 {
+			return rest[1 : pos+1], rest[0] == '"', true
+		}
+	}
+	return "", false, false
+}
+
+// Use the given prefix, like "#  ", for the given directive
+func restyleDirective(directive, prefix string) string {
+	_, name, rest, ok := parseDirective(directive)
+	if !ok || prefix == "" {
+		return directive
+	}
+	return prefix + name + rest
+}
+
+// Find the prefix (indentation and whitespace around "#") of the #include
+// directives closest to the given line index, within the same conditional block.
+// Returns "" if there are no #include directives nearby.
+func includePrefixNear(lines []line, index int) string {
+	return prefixNear(lines, index, func(name string) bool { return name == "include" })
+}
+
+// Find the prefix of the directives closest to the given line index, within
+// the same conditional block. #include directives are preferred, but any other
+// directive, like #define, is used if there are none. Returns "" if there are
+// no directives nearby.
+func directivePrefixNear(lines []line, index int) string {
+	if prefix := includePrefixNear(lines, index); prefix != "" {
+		return prefix
+	}
+	return prefixNear(lines, index, func(string) bool { return true })
+}
+
+// Find the prefix of the closest directive that matches, within the same
+// conditional block as the given line index
+func prefixNear(lines []line, index int, match func(name string) bool) string {
+	for i := index - 1; i >= 0; i-- {
+		prefix, name, _, ok := parseDirective(lines[i].text)
+		if ok && isConditionalDirective(lines[i].text) {
+			break
+		}
+		if ok && match(name) {
+			return prefix
+		}
+	}
+	for i := index; i < len(lines); i++ {
+		prefix, name, _, ok := parseDirective(lines[i].text)
+		if ok && (isConditionalDirective(lines[i].text) || name == "endif") {
+			break
+		}
+		if ok && match(name) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// Change the delimiters of the header in an #include directive to quotes or
+// angle brackets. Anything after the header, like a comment, is kept.
+func setDelimiters(text string, quoted bool) string {
+	prefix, name, rest, ok := parseDirective(text)
+	if !ok || name != "include" {
+		return text
+	}
+	trimmed := strings.TrimLeft(rest, " \t")
+	space := rest[:len(rest)-len(trimmed)]
+	header, isQuoted, ok := parseInclude(text)
+	if !ok {
+		return text
+	}
+	after := trimmed[len(header)+2:]
+	if quoted == isQuoted {
+		return text
+	}
+	if quoted {
+		return prefix + name + space + "\"" + header + "\"" + after
+	}
+	return prefix + name + space + "<" + header + ">" + after
+}
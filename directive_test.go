@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseDirective(t *testing.T) {
+	prefix, name, rest, ok := parseDirective("  #  include <x.h>")
+	assert.True(t, ok)
+	assert.Equal(t, "  #  ", prefix)
+	assert.Equal(t, "include", name)
+	assert.Equal(t, " <x.h>", rest)
+	assert.Equal(t, "ifdef", directiveName("#\tifdef X"))
+	assert.Equal(t, "", directiveName("int x; # include"))
+	name, quoted, ok := parseInclude("# include \"x.h\" // comment")
+	assert.True(t, ok)
+	assert.True(t, quoted)
+	assert.Equal(t, "x.h", name)
+}
+
+func TestWhitespaceDirectives(t *testing.T) {
+	testcontent := `#  ifdef SOMETHING
+
+#  include <blubbelubb.h>
+
+#endif
+`
+	source := newSourceCode(testcontent)
+	assert.True(t, source.hasIfdef())
+	assert.True(t, source.includes("blubbelubb.h"))
+	assert.Equal(t, 45, source.findInsertPos())
+}
+
+func TestRestyleDirective(t *testing.T) {
+	lines := splitLines("#if A\n#  include <a.h>\n#endif\n")
+	prefix := includePrefixNear(lines, 2)
+	assert.Equal(t, "#  ", prefix)
+	assert.Equal(t, "#  include <b.h>", restyleDirective("#include <b.h>", prefix))
+	assert.Equal(t, "", includePrefixNear(splitLines("#if A\n#endif\n"), 1))
+}
+
+func TestDirectivePrefixNear(t *testing.T) {
+	// Without includes nearby, the style of the other directives is used
+	lines := splitLines("#if A\n#  if B\n#    define X 1\n#  endif\n#endif\n")
+	assert.Equal(t, "", includePrefixNear(lines, 3))
+	assert.Equal(t, "#    ", directivePrefixNear(lines, 3))
+	assert.Equal(t, "", directivePrefixNear(splitLines("#if A\n#endif\n"), 1))
+
+	opts := defaultOptions()
+	opts.inBranch = "B"
+	result, err := addIncludeToText("x.c", joinLines(lines), "stdio", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#if A\n#  if B\n#    include <stdio.h>\n#    define X 1\n#  endif\n#endif\n", result)
+}
+
+func TestSetDelimiters(t *testing.T) {
+	assert.Equal(t, `#include "a.h"`, setDelimiters("#include <a.h>", true))
+	assert.Equal(t, "#  include\t<a/b.h> // c", setDelimiters("#  include\t\"a/b.h\" // c", false))
+	assert.Equal(t, "#include <a.h>", setDelimiters("#include <a.h>", false))
+	assert.Equal(t, "#define X", setDelimiters("#define X", true))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const editorConfigFilename = ".editorconfig"
+
+// Read the sections and the root setting from an .editorconfig file
+func readEditorConfig(filename string) ([]iniSection, bool, error) {
+	preamble, sections, err := readINI(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	return sections, strings.ToLower(preamble["root"]) == "true", nil
+}
+
+// Check if an .editorconfig section glob matches the given path, relative to
+// the directory of the .editorconfig file
+func editorConfigMatches(section iniSection, relpath string) bool {
+	glob := section.name
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+	return globMatch(strings.TrimPrefix(glob, "/"), relpath)
+}
+
+// Look up a property for the given file in the .editorconfig files in the
+// directory of the file and in the parent directories. The value is lowercase.
+func editorConfigValue(filename, key string) (string, bool) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", false
+	}
+	// Collect the .editorconfig files, from the closest to the root
+	var configs []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		configFilename := filepath.Join(dir, editorConfigFilename)
+		if _, root, err := readEditorConfig(configFilename); err == nil {
+			configs = append(configs, configFilename)
+			if root {
+				break
+			}
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	// Closer files and later sections take precedence
+	var (
+		value string
+		found bool
+	)
+	for i := len(configs) - 1; i >= 0; i-- {
+		sections, _, _ := readEditorConfig(configs[i])
+		relpath, err := filepath.Rel(filepath.Dir(configs[i]), abs)
+		if err != nil {
+			continue
+		}
+		for _, section := range sections {
+			if v, ok := section.properties[key]; ok && editorConfigMatches(section, filepath.ToSlash(relpath)) {
+				value, found = strings.ToLower(v), true
+			}
+		}
+	}
+	return value, found
+}
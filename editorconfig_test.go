@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditorConfigValue(t *testing.T) {
+	dir := t.TempDir()
+
+	sub := filepath.Join(dir, "src")
+	assert.Nil(t, os.Mkdir(sub, 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, editorConfigFilename), []byte("root = true\n\n[*]\ninsert_final_newline = true\n\n[*.h]\ninsert_final_newline = false\n"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(sub, editorConfigFilename), []byte("[main.c]\nInsert_Final_Newline = False\n"), 0644))
+
+	value, found := editorConfigValue(filepath.Join(sub, "other.c"), "insert_final_newline")
+	assert.True(t, found)
+	assert.Equal(t, "true", value)
+
+	value, _ = editorConfigValue(filepath.Join(sub, "other.h"), "insert_final_newline")
+	assert.Equal(t, "false", value)
+
+	value, _ = editorConfigValue(filepath.Join(sub, "main.c"), "insert_final_newline")
+	assert.Equal(t, "false", value)
+
+	_, found = editorConfigValue(filepath.Join(sub, "main.c"), "indent_style")
+	assert.False(t, found)
+}
@@ -0,0 +1,112 @@
+package main
+
+// textEdit replaces Length bytes at Offset in the original text with Text.
+// NewLine and NewOffset are where Text starts in the changed text, and Line is
+// where the replaced range starts in the original text (both counted from 1).
+type textEdit struct {
+	Line      int    `json:"line"`
+	Offset    int    `json:"offset"`
+	Length    int    `json:"length"`
+	Text      string `json:"text"`
+	NewLine   int    `json:"new_line"`
+	NewOffset int    `json:"new_offset"`
+}
+
+// lineHunk is a range of lines in the original text, [a0, a1), that is
+// replaced by a range of lines in the changed text, [b0, b1)
+type lineHunk struct {
+	a0, a1, b0, b1 int
+}
+
+// maxDiffArea is the largest number of line pairs to compare when finding
+// the longest common subsequence. Larger changes become a single hunk.
+const maxDiffArea = 4000000
+
+// Find the changed line ranges between two lists of lines
+func diffLines(a, b []line) []lineHunk {
+	// Skip the common prefix and suffix
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	s := 0
+	for s < len(a)-p && s < len(b)-p && a[len(a)-1-s] == b[len(b)-1-s] {
+		s++
+	}
+	a, b = a[p:len(a)-s], b[p:len(b)-s]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if len(a)*len(b) > maxDiffArea {
+		return []lineHunk{{p, p + len(a), p, p + len(b)}}
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var (
+		hunks   []lineHunk
+		current *lineHunk
+		i, j    int
+	)
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			current = nil
+			i++
+			j++
+			continue
+		}
+		if current == nil {
+			hunks = append(hunks, lineHunk{p + i, p + i, p + j, p + j})
+			current = &hunks[len(hunks)-1]
+		}
+		if j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]) {
+			j++
+			current.b1 = p + j
+		} else {
+			i++
+			current.a1 = p + i
+		}
+	}
+	return hunks
+}
+
+// Return the byte offset of each line, and of the end of the text
+func lineOffsets(lines []line) []int {
+	offsets := make([]int, len(lines)+1)
+	for i, l := range lines {
+		offsets[i+1] = offsets[i] + len(l.text) + len(l.eol)
+	}
+	return offsets
+}
+
+// Find the edits that turn the original text into the changed text
+func computeEdits(before, after string) []textEdit {
+	var (
+		a, b     = splitLines(before), splitLines(after)
+		aOffsets = lineOffsets(a)
+		bOffsets = lineOffsets(b)
+		edits    []textEdit
+	)
+	for _, h := range diffLines(a, b) {
+		edits = append(edits, textEdit{
+			Line:      h.a0 + 1,
+			Offset:    aOffsets[h.a0],
+			Length:    aOffsets[h.a1] - aOffsets[h.a0],
+			Text:      joinLines(b[h.b0:h.b1]),
+			NewLine:   h.b0 + 1,
+			NewOffset: bOffsets[h.b0],
+		})
+	}
+	return edits
+}
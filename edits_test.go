@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestComputeEdits(t *testing.T) {
+	before := "#include <a.h>\n\nint x;\n"
+	after := "#include <a.h>\n#include <b.h>\n\nint x;\n"
+	assert.Equal(t, []textEdit{{2, 15, 0, "#include <b.h>\n", 2, 15}}, computeEdits(before, after))
+
+	// Several edits, and a removed line
+	before = "#include <a.h>\n#include <b.h>\nint x;\n"
+	after = "#define _GNU_SOURCE\n#include <a.h>\nint x;\n"
+	assert.Equal(t, []textEdit{
+		{1, 0, 0, "#define _GNU_SOURCE\n", 1, 0},
+		{2, 15, 15, "", 3, 35},
+	}, computeEdits(before, after))
+
+	assert.Nil(t, computeEdits(before, before))
+}
+
+func TestDiffLines(t *testing.T) {
+	a := splitLines("a\nb\nc\n")
+	b := splitLines("a\nx\nc\nd\n")
+	assert.Equal(t, []lineHunk{{1, 2, 1, 2}, {3, 3, 3, 4}}, diffLines(a, b))
+}
+
+func TestFileEdits(t *testing.T) {
+	before := "x\n"
+	after := "é\nx\n"
+	edits := computeEdits(before, after)
+	et := &encodedText{encoding: encodingUTF16LE, bom: utf16LEBOM}
+	assert.Equal(t, []textEdit{{1, 2, 0, "é\n", 1, 2}}, et.fileEdits(before, after, edits))
+	et = &encodedText{encoding: encodingUTF8, bom: utf8BOM}
+	assert.Equal(t, []textEdit{{1, 3, 0, "é\n", 1, 3}}, et.fileEdits(before, after, edits))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The supported encodings for --encoding
+const (
+	encodingAuto        = "auto"
+	encodingUTF8        = "utf-8"
+	encodingLatin1      = "latin1"
+	encodingWindows1252 = "windows-1252"
+	encodingUTF16LE     = "utf-16le"
+	encodingUTF16BE     = "utf-16be"
+)
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+
+	// windows1252 has the runes for the bytes 0x80 to 0x9f. The bytes that are
+	// undefined in Windows-1252 are kept as the C1 control characters, as for Latin-1.
+	windows1252 = [32]rune{
+		0x20ac, 0x81, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+		0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x8d, 0x017d, 0x8f,
+		0x90, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+		0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x9d, 0x017e, 0x0178,
+	}
+
+	errOddLength = errors.New("UTF-16 text must have an even number of bytes")
+)
+
+// encodedText is the decoded text of a file, together with what is needed
+// for encoding it back to the same bytes
+type encodedText struct {
+	text     string
+	encoding string
+	bom      []byte
+}
+
+func isKnownEncoding(encoding string) bool {
+	switch encoding {
+	case encodingAuto, encodingUTF8, encodingLatin1, encodingWindows1252, encodingUTF16LE, encodingUTF16BE:
+		return true
+	}
+	return false
+}
+
+// Try to find the encoding of the given data, by looking at the byte order mark
+// and by checking if the data is valid UTF-8
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		return encodingUTF8
+	case bytes.HasPrefix(data, utf16LEBOM):
+		return encodingUTF16LE
+	case bytes.HasPrefix(data, utf16BEBOM):
+		return encodingUTF16BE
+	}
+	// UTF-16 without a byte order mark has many zero bytes, if the text is mostly ASCII.
+	// Zero bytes are valid UTF-8, but not expected in C source code.
+	if len(data)%2 == 0 {
+		var evenZeros, oddZeros int
+		for i, b := range data {
+			if b == 0 && i%2 == 0 {
+				evenZeros++
+			} else if b == 0 {
+				oddZeros++
+			}
+		}
+		if oddZeros > len(data)/4 && evenZeros == 0 {
+			return encodingUTF16LE
+		}
+		if evenZeros > len(data)/4 && oddZeros == 0 {
+			return encodingUTF16BE
+		}
+	}
+	if utf8.Valid(data) {
+		return encodingUTF8
+	}
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9f {
+			return encodingWindows1252
+		}
+	}
+	return encodingLatin1
+}
+
+// Decode the given data to UTF-8, using the given encoding or detecting it
+// if the encoding is "auto". A byte order mark is removed from the text.
+func decodeText(data []byte, encoding string) (*encodedText, error) {
+	if encoding == encodingAuto {
+		encoding = detectEncoding(data)
+	}
+	et := &encodedText{encoding: encoding}
+	switch encoding {
+	case encodingUTF8:
+		if bytes.HasPrefix(data, utf8BOM) {
+			et.bom, data = utf8BOM, data[len(utf8BOM):]
+		}
+		et.text = string(data)
+	case encodingLatin1, encodingWindows1252:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+			if encoding == encodingWindows1252 && b >= 0x80 && b <= 0x9f {
+				runes[i] = windows1252[b-0x80]
+			}
+		}
+		et.text = string(runes)
+	case encodingUTF16LE, encodingUTF16BE:
+		bom := utf16LEBOM
+		if encoding == encodingUTF16BE {
+			bom = utf16BEBOM
+		}
+		if bytes.HasPrefix(data, bom) {
+			et.bom, data = bom, data[len(bom):]
+		}
+		if len(data)%2 != 0 {
+			return nil, errOddLength
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if encoding == encodingUTF16LE {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		et.text = string(utf16.Decode(units))
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s", encoding)
+	}
+	return et, nil
+}
+
+// Encode the given text with the encoding and byte order mark that the
+// original text had
+func (et *encodedText) encode(text string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(et.bom)
+	switch et.encoding {
+	case encodingUTF8:
+		buf.WriteString(text)
+	case encodingLatin1, encodingWindows1252:
+		for _, r := range text {
+			b, ok := encodeByte(r, et.encoding == encodingWindows1252)
+			if !ok {
+				return nil, fmt.Errorf("%q can not be encoded as %s", r, et.encoding)
+			}
+			buf.WriteByte(b)
+		}
+	case encodingUTF16LE, encodingUTF16BE:
+		for _, unit := range utf16.Encode([]rune(text)) {
+			if et.encoding == encodingUTF16LE {
+				buf.WriteByte(byte(unit))
+				buf.WriteByte(byte(unit >> 8))
+			} else {
+				buf.WriteByte(byte(unit >> 8))
+				buf.WriteByte(byte(unit))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding: %s", et.encoding)
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode a rune as a Latin-1 or Windows-1252 byte
+func encodeByte(r rune, isWindows1252 bool) (byte, bool) {
+	if isWindows1252 {
+		for i, w := range windows1252 {
+			if w == r {
+				return byte(0x80 + i), true
+			}
+		}
+		if r >= 0x80 && r <= 0x9f {
+			return 0, false
+		}
+	}
+	if r > 0xff {
+		return 0, false
+	}
+	return byte(r), true
+}
+
+// Return the byte offset in the encoded file, including the byte order mark,
+// of the given offset in the text
+func (et *encodedText) byteOffset(text string, offset int) int {
+	if et.encoding == encodingUTF8 {
+		return len(et.bom) + offset
+	}
+	encoded, _ := et.encode(text[:offset])
+	return len(encoded)
+}
+
+// Convert the offsets and lengths of the given edits, from the original and
+// the changed text, to byte offsets and lengths in the encoded files
+func (et *encodedText) fileEdits(before, after string, edits []textEdit) []textEdit {
+	converted := make([]textEdit, len(edits))
+	for i, edit := range edits {
+		end := et.byteOffset(before, edit.Offset+edit.Length)
+		edit.Offset = et.byteOffset(before, edit.Offset)
+		edit.Length = end - edit.Offset
+		edit.NewOffset = et.byteOffset(after, edit.NewOffset)
+		converted[i] = edit
+	}
+	return converted
+}
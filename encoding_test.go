@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func roundTrip(t *testing.T, data []byte, encoding, expectedEncoding string) *encodedText {
+	decoded, err := decodeText(data, encoding)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedEncoding, decoded.encoding)
+	encoded, err := decoded.encode(decoded.text)
+	assert.Nil(t, err)
+	assert.Equal(t, data, encoded)
+	return decoded
+}
+
+func TestBOM(t *testing.T) {
+	decoded := roundTrip(t, []byte("\xef\xbb\xbfint x;\n"), encodingAuto, encodingUTF8)
+	assert.Equal(t, "int x;\n", decoded.text)
+	encoded, err := decoded.encode("#include <a.h>\n" + decoded.text)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("\xef\xbb\xbf#include <a.h>\nint x;\n"), encoded)
+}
+
+func TestLegacyEncodings(t *testing.T) {
+	decoded := roundTrip(t, []byte("/* \xe6\xf8\xe5 */\n"), encodingAuto, encodingLatin1)
+	assert.Equal(t, "/* æøå */\n", decoded.text)
+	decoded = roundTrip(t, []byte("/* \x93quoted\x94 \x80 \x81 */\n"), encodingAuto, encodingWindows1252)
+	assert.Equal(t, "/* “quoted” € \u0081 */\n", decoded.text)
+	_, err := decoded.encode("/* 世 */")
+	assert.NotNil(t, err)
+}
+
+func TestUTF16(t *testing.T) {
+	decoded := roundTrip(t, []byte("\xff\xfex\x00\n\x00"), encodingAuto, encodingUTF16LE)
+	assert.Equal(t, "x\n", decoded.text)
+	decoded = roundTrip(t, []byte("\x00x\x00\n"), encodingAuto, encodingUTF16BE)
+	assert.Equal(t, "x\n", decoded.text)
+	roundTrip(t, []byte("x\x00\n\x00"), encodingUTF16LE, encodingUTF16LE)
+	_, err := decodeText([]byte("x\x00\n"), encodingUTF16LE)
+	assert.NotNil(t, err)
+}
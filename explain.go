@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// explanation is a trace of how the insertion position was found, for --explain
+type explanation struct {
+	File       string               `json:"file"`
+	Directives []foundDirective     `json:"directives"`
+	Case       string               `json:"case"`
+	Candidates []placementCandidate `json:"candidates"`
+	Line       int                  `json:"line"`
+	Column     int                  `json:"column"`
+	Offset     int                  `json:"offset"`
+}
+
+// foundDirective is a preprocessor directive that was found in the file
+type foundDirective struct {
+	Directive string `json:"directive"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+}
+
+// placementCandidate is a position that was considered, and why
+type placementCandidate struct {
+	Reason string `json:"reason"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Offset int    `json:"offset"`
+}
+
+// Record the directives in the given source code
+func (e *explanation) directives(src *SourceCode) {
+	if e == nil {
+		return
+	}
+	e.Directives = []foundDirective{}
+	for i, l := range src.lines {
+		if prefix, name, _, ok := parseDirective(l.text); ok {
+			column := len(prefix) - len(strings.TrimLeft(prefix, " \t")) + 1
+			e.Directives = append(e.Directives, foundDirective{"#" + name, i + 1, column})
+		}
+	}
+}
+
+// Record which case of the switch in findInsertPos was used
+func (e *explanation) switchCase(name string) {
+	if e != nil {
+		e.Case = name
+	}
+}
+
+// Record a candidate position, given as a byte offset
+func (e *explanation) candidateOffset(src *SourceCode, reason string, offset int) {
+	if e == nil {
+		return
+	}
+	line, column := 1, offset+1
+	if len(src.lines) > 0 {
+		i := src.lineIndex(offset)
+		line, column = i+1, offset-src.lineStarts[i]+1
+	}
+	e.Candidates = append(e.Candidates, placementCandidate{reason, line, column, offset})
+}
+
+// Record a candidate position, given as the index of the line to insert before
+func (e *explanation) candidateLine(src *SourceCode, reason string, index int) {
+	if e == nil {
+		return
+	}
+	e.Candidates = append(e.Candidates, placementCandidate{reason, index + 1, 1, src.offsetOfLine(index)})
+}
+
+// Record the final position
+func (e *explanation) result(src *SourceCode, index int) {
+	if e == nil {
+		return
+	}
+	e.Line, e.Column, e.Offset = index+1, 1, src.offsetOfLine(index)
+}
+
+// Return the byte offset of the start of the line with the given index
+func (src *SourceCode) offsetOfLine(index int) int {
+	if index < len(src.lineStarts) {
+		return src.lineStarts[index]
+	}
+	return len(src.text)
+}
+
+// Write the explanation as a human-readable trace, followed by the same trace as JSON
+func (e *explanation) write(w io.Writer) error {
+	fmt.Fprintf(w, "Placement of the include in %s\n", e.File)
+	fmt.Fprintln(w, "Directives found:")
+	if len(e.Directives) == 0 {
+		fmt.Fprintln(w, "\tnone")
+	}
+	for _, d := range e.Directives {
+		fmt.Fprintf(w, "\t%d:%d\t%s\n", d.Line, d.Column, d.Directive)
+	}
+	if e.Case != "" {
+		fmt.Fprintf(w, "Case in findInsertPos: %s\n", e.Case)
+	}
+	fmt.Fprintln(w, "Candidates:")
+	for _, c := range e.Candidates {
+		fmt.Fprintf(w, "\t%d:%d\t(offset %d)\t%s\n", c.Line, c.Column, c.Offset, c.Reason)
+	}
+	fmt.Fprintf(w, "Result: the include is inserted as line %d, at %d:%d (offset %d)\n", e.Line, e.Line, e.Column, e.Offset)
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	source := newSourceCode("#ifdef SOMETHING\n\n#include <blubbelubb.h>\n\n#define SOMETHING\n#endif\n")
+	source.explain = &explanation{File: "x.h"}
+	index, err := source.findPlacement("x.h", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, index)
+
+	e := source.explain
+	assert.Equal(t, "hasInclude|hasIfdef", e.Case)
+	assert.Equal(t, 4, len(e.Directives))
+	assert.Equal(t, foundDirective{"#include", 3, 1}, e.Directives[1])
+	assert.Equal(t, placementCandidate{"the end of that line", 3, 24, 41}, e.Candidates[1])
+	assert.Equal(t, 4, e.Line)
+	assert.Equal(t, 1, e.Column)
+	assert.Equal(t, 42, e.Offset)
+
+	var buf bytes.Buffer
+	assert.Nil(t, e.write(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "Case in findInsertPos: hasInclude|hasIfdef")
+	assert.Contains(t, out, "at 4:1 (offset 42)")
+
+	var decoded explanation
+	assert.Nil(t, json.Unmarshal([]byte(out[strings.Index(out, "{"):]), &decoded))
+	assert.Equal(t, *e, decoded)
+}
+
+func TestExplainNil(t *testing.T) {
+	// Recording is a no-op when not explaining
+	source := newSourceCode("#include <stdio.h>\n")
+	index, err := source.findPlacement("x.c", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, index)
+	assert.Nil(t, source.explain)
+}
+
+func TestAddIncludeExplained(t *testing.T) {
+	opts := defaultOptions()
+	opts.explain = true
+	_, e, err := addIncludeExplained("x.c", "#include <stdio.h>\n", "stdlib", opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, e)
+	assert.Equal(t, 2, e.Line)
+	// Nothing is placed when the header is already included
+	_, e, err = addIncludeExplained("x.c", "#include <stdio.h>\n", "stdio", opts)
+	assert.Nil(t, err)
+	assert.Nil(t, e)
+}
+
+func TestExplainDefault(t *testing.T) {
+	source := newSourceCode("// SPDX-License-Identifier: MIT\n\nint main() {}\n")
+	source.explain = &explanation{}
+	index, err := source.findPlacement("x.c", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, index)
+	assert.Equal(t, "default", source.explain.Case)
+	assert.Equal(t, 2, source.explain.Line)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// macroSet is the macros that are given with -D and -U. Other macros are unknown,
+// so conditions that depend on them can be either true or false.
+type macroSet struct {
+	defined   map[string]string
+	undefined map[string]bool
+}
+
+func newMacroSet() *macroSet {
+	return &macroSet{make(map[string]string), make(map[string]bool)}
+}
+
+// Define a macro from a NAME or NAME=VALUE string, where the value defaults to 1
+func (macros *macroSet) define(spec string) {
+	name, value := spec, "1"
+	if pos := strings.Index(spec, "="); pos != -1 {
+		name, value = spec[:pos], spec[pos+1:]
+	}
+	macros.defined[name] = value
+	delete(macros.undefined, name)
+}
+
+func (macros *macroSet) undefine(name string) {
+	macros.undefined[name] = true
+	delete(macros.defined, name)
+}
+
+// tristate is the result of evaluating a condition that may depend on unknown macros
+type tristate int
+
+const (
+	unknown tristate = iota
+	alwaysFalse
+	alwaysTrue
+)
+
+// Evaluate a preprocessor condition, like "defined(X) && VERSION > 2"
+func (macros *macroSet) eval(condition string) tristate {
+	if macros == nil {
+		macros = newMacroSet()
+	}
+	p := &exprParser{tokens: tokenize(condition), macros: macros}
+	value, known := p.conditional()
+	if !known || p.pos != len(p.tokens) {
+		return unknown
+	}
+	if value != 0 {
+		return alwaysTrue
+	}
+	return alwaysFalse
+}
+
+// Split a condition into identifiers, numbers and operators
+func tokenize(condition string) []string {
+	var tokens []string
+	for i := 0; i < len(condition); {
+		c := rune(condition[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i
+			for j < len(condition) && (condition[j] == '_' || unicode.IsLetter(rune(condition[j])) || unicode.IsDigit(rune(condition[j]))) {
+				j++
+			}
+			tokens = append(tokens, condition[i:j])
+			i = j
+		default:
+			op := condition[i : i+1]
+			for _, two := range []string{"&&", "||", "==", "!=", "<=", ">=", "<<", ">>"} {
+				if strings.HasPrefix(condition[i:], two) {
+					op = two
+					break
+				}
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		}
+	}
+	return tokens
+}
+
+// exprParser is a recursive descent parser for preprocessor conditions.
+// Each method returns the value and if the value is known.
+type exprParser struct {
+	tokens []string
+	pos    int
+	macros *macroSet
+	depth  int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+// The binary operators, from the lowest to the highest precedence
+var binaryOperators = [][]string{
+	{"||"}, {"&&"}, {"|"}, {"^"}, {"&"}, {"==", "!="}, {"<", ">", "<=", ">="}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%"},
+}
+
+func (p *exprParser) conditional() (int64, bool) {
+	value, known := p.binary(0)
+	if p.peek() != "?" {
+		return value, known
+	}
+	p.next()
+	a, aKnown := p.conditional()
+	if p.next() != ":" {
+		return 0, false
+	}
+	b, bKnown := p.conditional()
+	switch {
+	case known && value != 0:
+		return a, aKnown
+	case known:
+		return b, bKnown
+	}
+	return 0, false
+}
+
+func (p *exprParser) binary(level int) (int64, bool) {
+	if level == len(binaryOperators) {
+		return p.unary()
+	}
+	left, leftKnown := p.binary(level + 1)
+	for {
+		op := p.peek()
+		found := false
+		for _, candidate := range binaryOperators[level] {
+			if op == candidate {
+				found = true
+			}
+		}
+		if !found {
+			return left, leftKnown
+		}
+		p.next()
+		right, rightKnown := p.binary(level + 1)
+		switch {
+		// A known operand may decide the result of && and || on its own
+		case op == "&&" && ((leftKnown && left == 0) || (rightKnown && right == 0)):
+			left, leftKnown = 0, true
+		case op == "||" && ((leftKnown && left != 0) || (rightKnown && right != 0)):
+			left, leftKnown = 1, true
+		case !leftKnown || !rightKnown:
+			left, leftKnown = 0, false
+		default:
+			left, leftKnown = apply(op, left, right)
+		}
+	}
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func apply(op string, a, b int64) (int64, bool) {
+	switch op {
+	case "&&":
+		return boolValue(a != 0 && b != 0), true
+	case "||":
+		return boolValue(a != 0 || b != 0), true
+	case "|":
+		return a | b, true
+	case "^":
+		return a ^ b, true
+	case "&":
+		return a & b, true
+	case "==":
+		return boolValue(a == b), true
+	case "!=":
+		return boolValue(a != b), true
+	case "<":
+		return boolValue(a < b), true
+	case ">":
+		return boolValue(a > b), true
+	case "<=":
+		return boolValue(a <= b), true
+	case ">=":
+		return boolValue(a >= b), true
+	case "<<":
+		return a << uint64(b), true
+	case ">>":
+		return a >> uint64(b), true
+	case "+":
+		return a + b, true
+	case "-":
+		return a - b, true
+	case "*":
+		return a * b, true
+	case "/", "%":
+		if b == 0 {
+			return 0, false
+		}
+		if op == "/" {
+			return a / b, true
+		}
+		return a % b, true
+	}
+	return 0, false
+}
+
+func (p *exprParser) unary() (int64, bool) {
+	switch op := p.peek(); op {
+	case "!", "~", "-", "+":
+		p.next()
+		value, known := p.unary()
+		switch op {
+		case "!":
+			return boolValue(value == 0), known
+		case "~":
+			return ^value, known
+		case "-":
+			return -value, known
+		}
+		return value, known
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (int64, bool) {
+	token := p.next()
+	switch {
+	case token == "(":
+		value, known := p.conditional()
+		if p.next() != ")" {
+			return 0, false
+		}
+		return value, known
+	case token == "defined":
+		name := p.next()
+		if name == "(" {
+			name = p.next()
+			if p.next() != ")" {
+				return 0, false
+			}
+		}
+		if _, ok := p.macros.defined[name]; ok {
+			return 1, true
+		}
+		return 0, p.macros.undefined[name]
+	case token != "" && unicode.IsDigit(rune(token[0])):
+		value, err := strconv.ParseInt(strings.TrimRight(strings.ToLower(token), "ul"), 0, 64)
+		return value, err == nil
+	case token != "" && (token[0] == '_' || unicode.IsLetter(rune(token[0]))):
+		if p.peek() == "(" {
+			// A function-like macro
+			for depth := 0; p.pos < len(p.tokens); {
+				switch p.next() {
+				case "(":
+					depth++
+				case ")":
+					depth--
+				}
+				if depth == 0 {
+					break
+				}
+			}
+			return 0, false
+		}
+		if value, ok := p.macros.defined[token]; ok && p.depth < 16 {
+			inner := &exprParser{tokens: tokenize(value), macros: p.macros, depth: p.depth + 1}
+			v, known := inner.conditional()
+			return v, known && inner.pos == len(inner.tokens)
+		}
+		// Undefined macros are 0, but only if they are known to be undefined
+		return 0, p.macros.undefined[token]
+	}
+	return 0, false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	macros := newMacroSet()
+	macros.define("HAVE_FOO")
+	macros.define("VERSION=3")
+	macros.undefine("NEVER_DEFINED")
+
+	assert.Equal(t, alwaysFalse, macros.eval("0"))
+	assert.Equal(t, alwaysTrue, macros.eval("1"))
+	assert.Equal(t, alwaysTrue, macros.eval("defined(HAVE_FOO)"))
+	assert.Equal(t, alwaysTrue, macros.eval("defined HAVE_FOO && VERSION >= 2"))
+	assert.Equal(t, alwaysFalse, macros.eval("defined(NEVER_DEFINED)"))
+	assert.Equal(t, alwaysFalse, macros.eval("NEVER_DEFINED"))
+	assert.Equal(t, unknown, macros.eval("defined(_WIN32)"))
+	assert.Equal(t, unknown, macros.eval("_WIN32"))
+	// A known operand can decide the result on its own
+	assert.Equal(t, alwaysFalse, macros.eval("defined(_WIN32) && 0"))
+	assert.Equal(t, alwaysTrue, macros.eval("defined(_WIN32) || VERSION == 3"))
+	assert.Equal(t, unknown, macros.eval("FOO(1) > 2"))
+	assert.Equal(t, alwaysTrue, macros.eval("(0x10 << 1) == 32 ? 1 : 0"))
+	assert.Equal(t, alwaysFalse, macros.eval("!1"))
+	assert.Equal(t, unknown, macros.eval("1 +"))
+}
+
+func TestDeadBranches(t *testing.T) {
+	testcontent := `#include <a.h>
+#if 0
+#include <old.h>
+#elif defined(NEVER_DEFINED)
+#include <never.h>
+#else
+#include <b.h>
+#endif
+`
+	macros := newMacroSet()
+	macros.undefine("NEVER_DEFINED")
+	tree := parseConditionals(splitLines(testcontent))
+	dead := tree.evaluate(macros)
+	assert.Equal(t, []bool{false, false, true, false, true, false, false, false}, dead)
+	index, ok := tree.liveIndex(3)
+	assert.True(t, ok)
+	assert.Equal(t, 6, index)
+
+	source := newSourceCode("#if 0\n#include <old.h>\n#endif\n")
+	assert.False(t, source.live(&Options{}).includes("old.h"))
+	index, err := source.findPlacement("x.c", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, index)
+
+	tree = parseConditionals(splitLines("#if 0\n#include <old.h>\n"))
+	tree.evaluate(macros)
+	_, ok = tree.liveIndex(2)
+	assert.False(t, ok)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// quotedString is a string that is always written as a double-quoted YAML
+// string, so that newlines and whitespace are kept exactly
+type quotedString string
+
+func (s quotedString) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Value: string(s)}, nil
+}
+
+// replacement replaces Length bytes at Offset in a file with ReplacementText,
+// as in the YAML files for clang-apply-replacements
+type replacement struct {
+	FilePath        string       `yaml:"FilePath"`
+	Offset          int          `yaml:"Offset"`
+	Length          int          `yaml:"Length"`
+	ReplacementText quotedString `yaml:"ReplacementText"`
+}
+
+// fixes is the changes that would be made, in the format of clang-apply-replacements
+type fixes struct {
+	MainSourceFile string        `yaml:"MainSourceFile"`
+	Replacements   []replacement `yaml:"Replacements"`
+}
+
+// Add the edits in the report as replacements, with the absolute path of the file
+func (f *fixes) add(report *fileReport) {
+	path, err := filepath.Abs(report.File)
+	if err != nil {
+		path = report.File
+	}
+	if f.MainSourceFile == "" {
+		f.MainSourceFile = path
+	}
+	for _, edit := range report.Edits {
+		f.Replacements = append(f.Replacements, replacement{path, edit.Offset, edit.Length, quotedString(edit.Text)})
+	}
+}
+
+// Write the fixes as a YAML document
+func (f *fixes) writeYAML(w io.Writer) error {
+	if f.Replacements == nil {
+		f.Replacements = []replacement{}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(f); err != nil {
+		return err
+	}
+	encoder.Close()
+	buf.WriteString("...\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Write the fixes to the given YAML file
+func (f *fixes) writeFile(filename string) error {
+	var buf bytes.Buffer
+	if err := f.writeYAML(&buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
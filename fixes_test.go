@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestExportFixes(t *testing.T) {
+	var f fixes
+	f.add(&fileReport{File: "/src/a.c", Edits: []textEdit{{Line: 2, Offset: 20, Text: "#include <stdio.h>\n"}}})
+	f.add(&fileReport{File: "/src/b.c", Edits: []textEdit{}})
+	assert.Equal(t, "/src/a.c", f.MainSourceFile)
+	assert.Equal(t, 1, len(f.Replacements))
+
+	var buf bytes.Buffer
+	assert.Nil(t, f.writeYAML(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "---\nMainSourceFile: /src/a.c\nReplacements:\n")
+	assert.Contains(t, out, "  ReplacementText: \"#include <stdio.h>\\n\"\n")
+	assert.Contains(t, out, "\n...\n")
+
+	var decoded struct {
+		Replacements []struct {
+			FilePath        string `yaml:"FilePath"`
+			Offset          int    `yaml:"Offset"`
+			Length          int    `yaml:"Length"`
+			ReplacementText string `yaml:"ReplacementText"`
+		} `yaml:"Replacements"`
+	}
+	assert.Nil(t, yaml.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, 20, decoded.Replacements[0].Offset)
+	assert.Equal(t, "#include <stdio.h>\n", decoded.Replacements[0].ReplacementText)
+}
+
+func TestExportNoFixes(t *testing.T) {
+	var (
+		f   fixes
+		buf bytes.Buffer
+	)
+	assert.Nil(t, f.writeYAML(&buf))
+	assert.Contains(t, buf.String(), "Replacements: []")
+}
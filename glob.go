@@ -0,0 +1,71 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Convert a glob pattern to a regular expression. "*" and "?" do not match "/",
+// "**" matches anything, "{a,b}" matches either alternative and "[...]" or
+// "[!...]" matches a set of characters.
+func globToRegexp(pattern string) string {
+	var (
+		sb     strings.Builder
+		braces int
+	)
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			i++
+			if i+1 < len(pattern) && pattern[i+1] == '/' {
+				// "**/" also matches no directories at all
+				i++
+				sb.WriteString("(?:.*/)?")
+			} else {
+				sb.WriteString(".*")
+			}
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '{':
+			braces++
+			sb.WriteString("(?:")
+		case c == '}' && braces > 0:
+			braces--
+			sb.WriteString(")")
+		case c == ',' && braces > 0:
+			sb.WriteString("|")
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end == -1 {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			set := pattern[i+1 : i+end]
+			if strings.HasPrefix(set, "!") {
+				set = "^" + set[1:]
+			}
+			sb.WriteString("[" + set + "]")
+			i += end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// Check if the given slash-separated path matches the glob pattern
+func globMatch(pattern, path string) bool {
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return false
+	}
+	return re.MatchString(path)
+}
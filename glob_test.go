@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	assert.True(t, globMatch("*.c", "main.c"))
+	assert.False(t, globMatch("*.c", "src/main.c"))
+	assert.True(t, globMatch("**/*.c", "main.c"))
+	assert.True(t, globMatch("**/*.c", "src/net/main.c"))
+	assert.True(t, globMatch("src/**", "src/net/main.c"))
+	assert.True(t, globMatch("*.{c,h}", "main.h"))
+	assert.False(t, globMatch("*.{c,h}", "main.cpp"))
+	assert.True(t, globMatch("file?.[ch]", "file1.h"))
+	assert.False(t, globMatch("file.[!ch]", "file.c"))
+}
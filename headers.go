@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Return the default system include directories that exist, like
+// /usr/include, the directories in CPATH and C_INCLUDE_PATH, or
+// CPLUS_INCLUDE_PATH in C++ mode, and INCLUDE on Windows
+func defaultSystemDirs(cpp bool) []string {
+	var dirs []string
+	envVars := []string{"CPATH", "C_INCLUDE_PATH"}
+	if cpp {
+		envVars[1] = "CPLUS_INCLUDE_PATH"
+	}
+	for _, envVar := range envVars {
+		dirs = append(dirs, filepath.SplitList(os.Getenv(envVar))...)
+	}
+	if runtime.GOOS == "windows" {
+		dirs = append(dirs, filepath.SplitList(os.Getenv("INCLUDE"))...)
+	} else {
+		var patterns []string
+		if cpp {
+			patterns = append(patterns, "/usr/include/c++/*", "/usr/include/*-linux-gnu/c++/*", "/usr/local/include/c++/*")
+		}
+		patterns = append(patterns,
+			"/usr/local/include",
+			"/usr/include/*-linux-gnu",
+			"/usr/lib/gcc/*/*/include",
+			"/usr/include",
+			"/opt/homebrew/include",
+			"/Library/Developer/CommandLineTools/SDKs/MacOSX.sdk/usr/include",
+		)
+		for _, pattern := range patterns {
+			matches, _ := filepath.Glob(pattern)
+			// The newest compiler version comes first
+			sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+			dirs = append(dirs, matches...)
+		}
+	}
+	var existing []string
+	for _, dir := range dirs {
+		if fi, err := os.Stat(dir); dir != "" && err == nil && fi.IsDir() {
+			existing = append(existing, dir)
+		}
+	}
+	return existing
+}
+
+// headerLocation is the kind of directory where a header was found
+type headerLocation int
+
+const (
+	locationNone    headerLocation = iota
+	locationLocal                  // the directory of the including file
+	locationQuote                  // an -iquote directory
+	locationInclude                // an -I directory
+	locationSystem                 // an -isystem directory or a default system directory
+)
+
+// searchDir is a directory to search for headers
+type searchDir struct {
+	path     string
+	location headerLocation
+}
+
+// Return the directories to search for a header in the given file, in order.
+// Headers in quotes are first looked for in the directory of the file, and
+// then in the -iquote directories.
+func (opts *Options) searchPath(filename string, quoted bool) []searchDir {
+	var dirs []searchDir
+	add := func(location headerLocation, paths ...string) {
+		for _, path := range paths {
+			dirs = append(dirs, searchDir{path, location})
+		}
+	}
+	if quoted {
+		add(locationLocal, filepath.Dir(filename))
+		add(locationQuote, opts.quoteDirs...)
+	}
+	add(locationInclude, opts.includeDirs...)
+	add(locationSystem, opts.systemDirs...)
+	if !opts.noStdInc {
+		add(locationSystem, defaultSystemDirs(opts.cppStyle)...)
+	}
+	return dirs
+}
+
+// Return the paths of the directories to search for a header in the given file, in order
+func (opts *Options) searchDirs(filename string, quoted bool) []string {
+	var paths []string
+	for _, dir := range opts.searchPath(filename, quoted) {
+		paths = append(paths, dir.path)
+	}
+	return paths
+}
+
+// Find the header in the include search paths for the given file, as if it
+// were in quotes. Returns the path and the kind of directory it was found in.
+func (opts *Options) locateHeader(filename, name string) (string, headerLocation) {
+	for _, dir := range opts.searchPath(filename, true) {
+		path := filepath.Join(dir.path, filepath.FromSlash(name))
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path, dir.location
+		}
+	}
+	return "", locationNone
+}
+
+// Find the header in the include search paths for the given file.
+// Returns the path to the header, or false if it was not found.
+func (opts *Options) resolveHeader(filename, name string, quoted bool) (string, bool) {
+	for _, path := range opts.searchDirs(filename, quoted) {
+		path = filepath.Join(path, filepath.FromSlash(name))
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Decide if the header should be in quotes, from where it is found. Headers
+// next to the file or in -iquote directories use quotes, and headers in system
+// directories use angle brackets. For headers in -I directories, and headers
+// that are not found, the given spelling is kept.
+func (opts *Options) quoteStyle(filename, name string, quoted bool) bool {
+	switch _, location := opts.locateHeader(filename, name); location {
+	case locationLocal, locationQuote:
+		return true
+	case locationSystem:
+		return false
+	}
+	return quoted
+}
+
+// Return the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// Find the headers in the include search paths with the names that are
+// closest to the given name. At most three are returned.
+func (opts *Options) suggestHeaders(filename, name string, quoted bool) []string {
+	var (
+		subdir    = filepath.Dir(filepath.FromSlash(name))
+		maxDist   = max(2, len(name)/4)
+		distances = make(map[string]int)
+	)
+	for _, dir := range opts.searchDirs(filename, quoted) {
+		entries, err := ioutil.ReadDir(filepath.Join(dir, subdir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			// C++ standard headers, like <vector>, have no extension
+			if entry.IsDir() || (filepath.Ext(entry.Name()) != "" && !hasExtension(entry.Name(), headerExtensions)) {
+				continue
+			}
+			candidate := filepath.ToSlash(filepath.Join(subdir, entry.Name()))
+			if d := levenshtein(name, candidate); d <= maxDist {
+				distances[candidate] = d
+			}
+		}
+	}
+	var suggestions []string
+	for candidate := range distances {
+		suggestions = append(suggestions, candidate)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+	for i, candidate := range suggestions {
+		if distances[candidate] > distances[suggestions[0]] {
+			suggestions = suggestions[:i]
+			break
+		}
+	}
+	if len(suggestions) > 3 {
+		suggestions = suggestions[:3]
+	}
+	return suggestions
+}
+
+// Check that the header can be found in the include search paths. Returns an
+// error with suggestions for similar headers if it can not be found.
+func (opts *Options) verifyHeader(filename, name string, quoted bool) error {
+	if _, ok := opts.resolveHeader(filename, name, quoted); ok {
+		return nil
+	}
+	msg := fmt.Sprintf("%s was not found in the include paths", name)
+	if suggestions := opts.suggestHeaders(filename, name, quoted); len(suggestions) > 0 {
+		msg += ". Did you mean " + strings.Join(suggestions, ", ") + "?"
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// Check if the include argument is the path to a header file that exists,
+// like include/proj/util.h, and not a header name, like stdio or <stdio.h>.
+// Other files, like README or foo.c, are not headers.
+func headerPath(include string) (string, bool) {
+	if include == "" || strings.ContainsAny(include, " <>\"") || !hasExtension(include, headerExtensions) {
+		return "", false
+	}
+	if fi, err := os.Stat(include); err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	return include, true
+}
+
+// Return the include directive for the header at the given path, with the
+// shortest spelling relative to the directory of the file or to one of the
+// include directories. Headers in system directories get angle brackets.
+// If the header is in none of them, the path is relative to the file.
+func (opts *Options) includeForPath(filename, path string) string {
+	var (
+		headerAbs, _ = filepath.Abs(path)
+		fileDir, _   = filepath.Abs(filepath.Dir(filename))
+		best         string
+		bestQuoted   = true
+	)
+	// Count the directories, and then the characters
+	shorter := func(a, b string) bool {
+		if na, nb := strings.Count(a, "/"), strings.Count(b, "/"); na != nb {
+			return na < nb
+		}
+		return len(a) < len(b)
+	}
+	for _, dir := range opts.searchPath(filename, true) {
+		root, err := filepath.Abs(dir.path)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, headerAbs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if best == "" || shorter(rel, best) {
+			best, bestQuoted = rel, dir.location != locationSystem
+		}
+	}
+	if best == "" {
+		rel, err := filepath.Rel(fileDir, headerAbs)
+		if err != nil {
+			rel = headerAbs
+		}
+		best = filepath.ToSlash(rel)
+	}
+	if bestQuoted {
+		return incl + " \"" + best + "\""
+	}
+	return incl + " <" + best + ">"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create an include directory with the given headers, and options that only
+// search that directory
+func testIncludeDir(t *testing.T, headers ...string) (string, *Options) {
+	dir := t.TempDir()
+	for _, header := range headers {
+		path := filepath.Join(dir, filepath.FromSlash(header))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, os.WriteFile(path, []byte{}, 0644))
+	}
+	opts := defaultOptions()
+	opts.includeDirs = []string{dir}
+	opts.noStdInc = true
+	return dir, opts
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("stdio.h", "stdio.h"))
+	assert.Equal(t, 1, levenshtein("stdin.h", "stdio.h"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+	assert.Equal(t, 3, levenshtein("", "abc"))
+}
+
+func TestResolveHeader(t *testing.T) {
+	dir, opts := testIncludeDir(t, "stdio.h", "sys/types.h", "vector")
+	path, ok := opts.resolveHeader("x.c", "sys/types.h", false)
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(dir, "sys", "types.h"), path)
+	_, ok = opts.resolveHeader("x.c", "stdin.h", false)
+	assert.False(t, ok)
+
+	// Quoted headers are also looked for next to the file
+	_, ok = opts.resolveHeader(filepath.Join(dir, "sys", "x.c"), "types.h", true)
+	assert.True(t, ok)
+	_, ok = opts.resolveHeader(filepath.Join(dir, "sys", "x.c"), "types.h", false)
+	assert.False(t, ok)
+}
+
+func TestVerifyHeader(t *testing.T) {
+	_, opts := testIncludeDir(t, "stdio.h", "stdlib.h", "string.h", "vector", "README")
+	assert.Nil(t, opts.verifyHeader("x.c", "stdio.h", false))
+	err := opts.verifyHeader("x.c", "stdin.h", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, "stdin.h was not found in the include paths. Did you mean stdio.h?", err.Error())
+	assert.Equal(t, []string{"vector"}, opts.suggestHeaders("x.cpp", "vectr", false))
+	assert.Empty(t, opts.suggestHeaders("x.c", "completely_different.h", false))
+}
+
+func TestStrict(t *testing.T) {
+	_, opts := testIncludeDir(t, "stdio.h")
+	opts.strict = true
+	_, err := addIncludeToText("x.c", "int x;\n", "stdin", opts)
+	assert.NotNil(t, err)
+	text, err := addIncludeToText("x.c", "int x;\n", "stdio", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#include <stdio.h>\n\nint x;\n", text)
+}
+
+func TestQuoteStyle(t *testing.T) {
+	dir, opts := testIncludeDir(t, "include/proj.h", "quote/util.h", "system/stdio.h", "src/local.h")
+	opts.includeDirs = []string{filepath.Join(dir, "include")}
+	opts.quoteDirs = []string{filepath.Join(dir, "quote")}
+	opts.systemDirs = []string{filepath.Join(dir, "system")}
+	filename := filepath.Join(dir, "src", "a.c")
+	assert.True(t, opts.quoteStyle(filename, "local.h", false))
+	assert.True(t, opts.quoteStyle(filename, "util.h", false))
+	assert.False(t, opts.quoteStyle(filename, "stdio.h", true))
+	// The spelling is kept for -I directories and for headers that are not found
+	assert.True(t, opts.quoteStyle(filename, "proj.h", true))
+	assert.False(t, opts.quoteStyle(filename, "proj.h", false))
+	assert.False(t, opts.quoteStyle(filename, "missing.h", false))
+
+	opts.autoStyle = true
+	text, err := addIncludeToText(filename, "int x;\n", "util", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#include \"util.h\"\n\nint x;\n", text)
+}
+
+func TestIncludeForPath(t *testing.T) {
+	dir, opts := testIncludeDir(t, "include/proj/util/strbuf.h", "src/net/conn.h", "system/sys/types.h", "README", "src/net/conn.c")
+	opts.includeDirs = nil
+	filename := filepath.Join(dir, "src", "net", "conn.c")
+	header := filepath.Join(dir, "include", "proj", "util", "strbuf.h")
+
+	path, ok := headerPath(header)
+	assert.True(t, ok)
+	_, ok = headerPath("<stdio.h>")
+	assert.False(t, ok)
+	_, ok = headerPath(filepath.Join(dir, "include"))
+	assert.False(t, ok)
+	// Only files with a header extension are headers
+	_, ok = headerPath(filepath.Join(dir, "README"))
+	assert.False(t, ok)
+	_, ok = headerPath(filepath.Join(dir, "src", "net", "conn.c"))
+	assert.False(t, ok)
+
+	assert.Equal(t, `#include "../../include/proj/util/strbuf.h"`, opts.includeForPath(filename, path))
+	assert.Equal(t, `#include "conn.h"`, opts.includeForPath(filename, filepath.Join(dir, "src", "net", "conn.h")))
+	opts.includeDirs = []string{filepath.Join(dir, "include")}
+	assert.Equal(t, `#include "proj/util/strbuf.h"`, opts.includeForPath(filename, path))
+	opts.systemDirs = []string{filepath.Join(dir, "system")}
+	assert.Equal(t, "#include <sys/types.h>", opts.includeForPath(filename, filepath.Join(dir, "system", "sys", "types.h")))
+
+	directive, err := opts.includeDirective(filename, header)
+	assert.Nil(t, err)
+	assert.Equal(t, `#include "proj/util/strbuf.h"`, directive)
+	opts.fixInclude = false
+	directive, err = opts.includeDirective(filename, header)
+	assert.Nil(t, err)
+	assert.Equal(t, header, directive)
+}
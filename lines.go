@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// line is a line of text, together with the line ending it had
+type line struct {
+	text string
+	eol  string
+}
+
+// Split the text into lines, keeping the line endings.
+// Lines may end with \n, \r\n or \r, also within the same text.
+func splitLines(text string) []line {
+	var lines []line
+	for text != "" {
+		pos := strings.IndexAny(text, "\r\n")
+		switch {
+		case pos == -1:
+			lines = append(lines, line{text, ""})
+			text = ""
+		case strings.HasPrefix(text[pos:], dosEOL):
+			lines = append(lines, line{text[:pos], dosEOL})
+			text = text[pos+2:]
+		default:
+			lines = append(lines, line{text[:pos], text[pos : pos+1]})
+			text = text[pos+1:]
+		}
+	}
+	return lines
+}
+
+// Join the lines, including the line endings, back into one string
+func joinLines(lines []line) string {
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(l.text)
+		sb.WriteString(l.eol)
+	}
+	return sb.String()
+}
+
+func isBlank(text string) bool { return strings.TrimSpace(text) == "" }
+
+func isDirective(text string) bool {
+	_, _, _, ok := parseDirective(text)
+	return ok
+}
+
+func isIncludeDirective(text string) bool {
+	return directiveName(text) == "include"
+}
+
+// Check if the line starts or continues a conditional block, like #ifdef or #else
+func isConditionalDirective(text string) bool {
+	switch directiveName(text) {
+	case "if", "ifdef", "ifndef", "elif", "else", "elifdef", "elifndef":
+		return true
+	}
+	return false
+}
+
+// Count how many lines have each kind of line ending
+func lineEndingCounts(lines []line) map[string]int {
+	counts := make(map[string]int)
+	for _, l := range lines {
+		if l.eol != "" {
+			counts[l.eol]++
+		}
+	}
+	return counts
+}
+
+// Describe the line ending counts, for instance "10 LF, 2 CRLF"
+func describeLineEndings(counts map[string]int) string {
+	var parts []string
+	for _, eol := range []string{unixEOL, dosEOL, macEOL} {
+		if counts[eol] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[eol], eolName(eol)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func eolName(eol string) string {
+	switch eol {
+	case dosEOL:
+		return "CRLF"
+	case macEOL:
+		return "CR"
+	default:
+		return "LF"
+	}
+}
+
+// Return the line ending for the given --eol style, or "" for "keep"
+func eolOption(style string) string {
+	switch style {
+	case "lf":
+		return unixEOL
+	case "crlf":
+		return dosEOL
+	case "native":
+		if runtime.GOOS == "windows" {
+			return dosEOL
+		}
+		return unixEOL
+	}
+	return ""
+}
+
+// Use the given line ending for all lines that have a line ending
+func normalizeLineEndings(lines []line, eol string) []line {
+	for i := range lines {
+		if lines[i].eol != "" {
+			lines[i].eol = eol
+		}
+	}
+	return lines
+}
+
+// Insert a directive as a new line before the line at the given index.
+// A blank line is added before it only when it starts a new group of directives,
+// and after it only if the next line is code. Existing blank lines are reused.
+// The line ending of the neighboring lines is used, or the given newline if
+// there are none.
+func insertLine(lines []line, index int, directive, newline string) []line {
+	return insertLines(lines, index, []string{directive}, newline)
+}
+
+// Insert several directives as a block of lines, in the same way as insertLine
+func insertLines(lines []line, index int, directives []string, newline string) []line {
+	var (
+		hasPrev  = index > 0
+		hasNext  = index < len(lines)
+		prev     string
+		next     string
+		newGroup bool
+	)
+	if hasPrev {
+		prev = lines[index-1].text
+	}
+	if hasNext {
+		next = lines[index].text
+	}
+	if hasPrev && lines[index-1].eol != "" {
+		newline = lines[index-1].eol
+	} else if hasNext && lines[index].eol != "" {
+		newline = lines[index].eol
+	}
+	newGroup = !(hasPrev && isIncludeDirective(prev)) && !(hasNext && isIncludeDirective(next))
+
+	var inserted []line
+	for _, directive := range directives {
+		inserted = append(inserted, line{directive, newline})
+	}
+	if newGroup && hasPrev && !isBlank(prev) && !isConditionalDirective(prev) {
+		inserted = append([]line{{"", newline}}, inserted...)
+	}
+	if hasNext && !isBlank(next) && !isDirective(next) {
+		inserted = append(inserted, line{"", newline})
+	}
+
+	result := make([]line, 0, len(lines)+len(inserted))
+	result = append(result, lines[:index]...)
+	if hasPrev && lines[index-1].eol == "" {
+		// The previous line was the last line and had no line ending.
+		// It needs one now, but the file is kept without a final newline.
+		result[index-1].eol = newline
+		inserted[len(inserted)-1].eol = ""
+	}
+	result = append(result, inserted...)
+	return append(result, lines[index:]...)
+}
+
+// Add a line ending to the last line, if it is missing
+func ensureFinalNewline(lines []line, newline string) []line {
+	if len(lines) > 0 && lines[len(lines)-1].eol == "" {
+		lines[len(lines)-1].eol = newline
+	}
+	return lines
+}
+
+// Remove the line at the given index. If that leaves two blank lines next to
+// each other, or a blank line at the start of the file, one of them is removed
+// too. A file without a final newline is kept that way.
+func removeLine(lines []line, index int) []line {
+	removed := lines[index]
+	result := append(append([]line{}, lines[:index]...), lines[index+1:]...)
+	if index < len(result) && isBlank(result[index].text) && (index == 0 || isBlank(result[index-1].text)) {
+		result = append(result[:index], result[index+1:]...)
+	}
+	if index >= len(result) && len(result) > 0 && removed.eol == "" {
+		result[len(result)-1].eol = ""
+	}
+	return result
+}
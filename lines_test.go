@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func insertInto(text string, index int, directive string) string {
+	return joinLines(insertLine(splitLines(text), index, directive, unixEOL))
+}
+
+func TestSplitLines(t *testing.T) {
+	testcontent := "a\r\nb\nc"
+	lines := splitLines(testcontent)
+	assert.Equal(t, []line{{"a", dosEOL}, {"b", unixEOL}, {"c", ""}}, lines)
+	assert.Equal(t, testcontent, joinLines(lines))
+	assert.Empty(t, splitLines(""))
+}
+
+func TestInsertLineInGroup(t *testing.T) {
+	testcontent := "#include <a.h>\n#include <b.h>\n\nint x;\n"
+	assert.Equal(t, "#include <a.h>\n#include <c.h>\n#include <b.h>\n\nint x;\n", insertInto(testcontent, 1, "#include <c.h>"))
+	assert.Equal(t, "#include <a.h>\n#include <b.h>\n#include <c.h>\n\nint x;\n", insertInto(testcontent, 2, "#include <c.h>"))
+}
+
+func TestInsertLineNewGroup(t *testing.T) {
+	assert.Equal(t, "#include <c.h>\n\nint x;\n", insertInto("int x;\n", 0, "#include <c.h>"))
+	assert.Equal(t, "#ifdef A\n#include <c.h>\n#endif\n", insertInto("#ifdef A\n#endif\n", 1, "#include <c.h>"))
+	assert.Equal(t, "#define A\n\n#include <c.h>\n", insertInto("#define A\n", 1, "#include <c.h>"))
+	assert.Equal(t, "/* hi */\n\n#include <c.h>\n\nint x;\n", insertInto("/* hi */\n\nint x;\n", 2, "#include <c.h>"))
+}
+
+func TestInsertLineAtEnd(t *testing.T) {
+	// A file without a final newline stays that way
+	assert.Equal(t, "#include <a.h>\n#include <c.h>", insertInto("#include <a.h>", 1, "#include <c.h>"))
+	assert.Equal(t, "#include <a.h>\n#include <c.h>\n", insertInto("#include <a.h>\n", 1, "#include <c.h>"))
+	assert.Equal(t, "#include <c.h>\n", insertInto("", 0, "#include <c.h>"))
+	assert.Equal(t, "a\nb\n", joinLines(ensureFinalNewline(splitLines("a\nb"), unixEOL)))
+}
+
+func TestFinalNewlineOnlyWhenChanged(t *testing.T) {
+	opts := defaultOptions()
+	opts.finalNewline = true
+	result, err := addIncludeToText("x.c", "#include <stdio.h>", "stdio", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#include <stdio.h>", result)
+	result, err = addIncludeToText("x.c", "#include <stdio.h>", "stdlib", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#include <stdio.h>\n#include <stdlib.h>\n", result)
+}
+
+func TestLastLine(t *testing.T) {
+	source := newSourceCode("#ifdef X\n#include <a.h>")
+	assert.Equal(t, 23, source.findInsertPos())
+	assert.Equal(t, 2, source.lineAfter(source.findInsertPos()))
+}
+
+func TestMixedLineEndings(t *testing.T) {
+	testcontent := "#include <a.h>\r\n#include <b.h>\n\rint x;\r"
+	lines := splitLines(testcontent)
+	assert.Equal(t, []line{{"#include <a.h>", dosEOL}, {"#include <b.h>", unixEOL}, {"", macEOL}, {"int x;", macEOL}}, lines)
+	assert.Equal(t, "1 LF, 1 CRLF, 2 CR", describeLineEndings(lineEndingCounts(lines)))
+	// The inserted line gets the line ending of the line above it
+	assert.Equal(t, "#include <a.h>\r\n#include <c.h>\r\n#include <b.h>\n\rint x;\r", joinLines(insertLine(lines, 1, "#include <c.h>", unixEOL)))
+	assert.Equal(t, "#include <a.h>\n#include <b.h>\n\nint x;\n", joinLines(normalizeLineEndings(splitLines(testcontent), unixEOL)))
+}
+
+func TestMacLineEndings(t *testing.T) {
+	source := newSourceCode("#ifdef X\r#include <a.h>\rint x;\r")
+	assert.Equal(t, macEOL, source.getNewline())
+	assert.Equal(t, 23, source.findInsertPos())
+	assert.Equal(t, 2, source.lineAfter(source.findInsertPos()))
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The rule IDs of the policy rules, as used in the output
+const (
+	requiredIncludeRule  = "required-include"
+	forbiddenIncludeRule = "forbidden-include"
+	includeOrderRule     = "include-order"
+)
+
+// The descriptions of the policy rules, by rule ID
+var policyRuleDescriptions = map[string]string{
+	requiredIncludeRule:  "A header that is required by the include policy is not included",
+	forbiddenIncludeRule: "A header that is forbidden by the include policy is included",
+	includeOrderRule:     "Headers are not included in the order given by the include policy",
+}
+
+// headerPattern is a header in a policy file, like <iostream>, "internal/*.h"
+// or stdio.h. The name may be a glob pattern. Without delimiters, it matches
+// both quoted includes and includes with angle brackets.
+type headerPattern struct {
+	name  string
+	delim byte // '<', '"' or 0
+}
+
+// Parse a header pattern, like <iostream> or "internal/*.h"
+func parseHeaderPattern(s string) headerPattern {
+	if len(s) > 2 && ((s[0] == '<' && s[len(s)-1] == '>') || (s[0] == '"' && s[len(s)-1] == '"')) {
+		return headerPattern{s[1 : len(s)-1], s[0]}
+	}
+	return headerPattern{s, 0}
+}
+
+func (p headerPattern) String() string {
+	switch p.delim {
+	case '<':
+		return "<" + p.name + ">"
+	case '"':
+		return "\"" + p.name + "\""
+	}
+	return p.name
+}
+
+// Check if the pattern matches an include of the given header name
+func (p headerPattern) matches(name string, quoted bool) bool {
+	if (p.delim == '<' && quoted) || (p.delim == '"' && !quoted) {
+		return false
+	}
+	return globMatch(p.name, name)
+}
+
+// Check if the pattern is a glob pattern, and not a single header
+func (p headerPattern) isGlob() bool {
+	return strings.ContainsAny(p.name, "*?[{")
+}
+
+// Return the include directive for the header. Headers without delimiters
+// use angle brackets.
+func (p headerPattern) directive() string {
+	if p.delim == '"' {
+		return incl + " \"" + p.name + "\""
+	}
+	return incl + " <" + p.name + ">"
+}
+
+// policyRule is a [require GLOB], [forbid GLOB] or [order GLOB] section in a policy file
+type policyRule struct {
+	kind    string
+	files   string   // a glob pattern for the files the rule applies to
+	except  []string // glob patterns for files the rule does not apply to
+	headers []headerPattern
+}
+
+// Check if the rule applies to the given path, relative to the policy file
+func (rule *policyRule) appliesTo(relpath string) bool {
+	if !globMatch(rule.files, relpath) {
+		return false
+	}
+	for _, pattern := range rule.except {
+		if globMatch(pattern, relpath) {
+			return false
+		}
+	}
+	return true
+}
+
+// policy is the include rules from a policy file. File patterns are relative
+// to the directory of the policy file.
+type policy struct {
+	dir   string
+	rules []policyRule
+}
+
+// Read the include policy from the given INI-style file, like this:
+//
+//	[require src/net/**]
+//	headers = "net_config.h"
+//
+//	[forbid **/*.h]
+//	headers = <iostream>
+//
+//	[forbid **]
+//	headers = "internal/*.h"
+//	except = src/internal/**
+//
+//	[order **]
+//	headers = <windows.h> <winsock2.h>
+//
+// Other sections, like [platform NAME], are ignored.
+func readPolicy(filename string) (*policy, error) {
+	_, sections, err := readINI(filename)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	p := &policy{dir: dir}
+	for _, section := range sections {
+		fields := strings.Fields(section.name)
+		if len(fields) == 0 || len(fields) > 2 {
+			continue
+		}
+		switch fields[0] {
+		case "require", "forbid", "order":
+		default:
+			continue
+		}
+		rule := policyRule{kind: fields[0], files: "**", except: strings.Fields(section.properties["except"])}
+		if len(fields) == 2 {
+			rule.files = fields[1]
+		}
+		for _, header := range strings.Fields(section.properties["headers"]) {
+			rule.headers = append(rule.headers, parseHeaderPattern(header))
+		}
+		if len(rule.headers) == 0 {
+			return nil, fmt.Errorf("%s: no headers in [%s]", filename, section.name)
+		}
+		p.rules = append(p.rules, rule)
+	}
+	if len(p.rules) == 0 {
+		return nil, fmt.Errorf("%s: no [require], [forbid] or [order] sections", filename)
+	}
+	return p, nil
+}
+
+// Find the policy file, which is the closest .addinclude.conf in the current
+// directory or one of its parents
+func findPolicyFile() (string, bool) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	for ; ; dir = filepath.Dir(dir) {
+		filename := filepath.Join(dir, configFilename)
+		if _, err := os.Stat(filename); err == nil {
+			return filename, true
+		}
+		if dir == filepath.Dir(dir) {
+			return "", false
+		}
+	}
+}
+
+// violation is a broken policy rule at a line in a file
+type violation struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Fixable bool   `json:"fixable"`
+	fix     func(filetext string) (string, error)
+}
+
+// Return the path of the given file relative to the policy file, with forward slashes
+func (p *policy) relpath(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	rel, err := filepath.Rel(p.dir, abs)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Find the violations of the policy in the given file
+func (p *policy) lint(filename, filetext string) []violation {
+	var (
+		relpath    = p.relpath(filename)
+		src        = newSourceCode(filetext)
+		opts       = defaultOptions()
+		live       = src.live(opts)
+		reachable  = src.reachable(opts)
+		violations []violation
+	)
+	// Find the first line index of an include that matches the pattern in
+	// the given source code, or -1
+	find := func(src *SourceCode, pattern headerPattern) int {
+		for _, include := range src.includeLines() {
+			if pattern.matches(include.name, include.quoted) {
+				return src.lineIndex(include.start)
+			}
+		}
+		return -1
+	}
+	for _, rule := range p.rules {
+		if !rule.appliesTo(relpath) {
+			continue
+		}
+		switch rule.kind {
+		case "require":
+			for _, header := range rule.headers {
+				if find(reachable, header) != -1 {
+					continue
+				}
+				v := violation{File: filename, Line: 1, Rule: requiredIncludeRule, Message: "missing required #include " + header.String()}
+				if !header.isGlob() {
+					directive := header.directive()
+					v.Fixable = true
+					v.fix = func(filetext string) (string, error) {
+						opts := defaultOptions()
+						opts.fixInclude = false
+						return addIncludeToText(filename, filetext, directive, opts)
+					}
+				}
+				violations = append(violations, v)
+			}
+		case "forbid":
+			protected := protectedLines(src.lines)
+			for i, l := range src.lines {
+				name, quoted, ok := parseInclude(l.text)
+				if !ok {
+					continue
+				}
+				for _, header := range rule.headers {
+					if header.matches(name, quoted) {
+						index := i
+						violations = append(violations, violation{filename, i + 1, forbiddenIncludeRule, "forbidden #include " + headerOf(l.text), !protected[i], func(filetext string) (string, error) {
+							return joinLines(removeLine(splitLines(filetext), index)), nil
+						}})
+						break
+					}
+				}
+			}
+		case "order":
+			positions := make([]int, len(rule.headers))
+			for i, header := range rule.headers {
+				positions[i] = find(live, header)
+			}
+			for i := range rule.headers {
+				for j := i + 1; j < len(rule.headers); j++ {
+					if positions[i] == -1 || positions[j] == -1 || positions[i] < positions[j] {
+						continue
+					}
+					from, to := positions[i], positions[j]
+					violations = append(violations, violation{filename, from + 1, includeOrderRule, rule.headers[i].String() + " must be included before " + rule.headers[j].String(), true, func(filetext string) (string, error) {
+						return moveLine(filetext, from, to)
+					}})
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// Apply the fixes for the violations of the policy in the given file, one at a
+// time, until there is nothing more to fix
+func (p *policy) fix(filename, filetext string) (string, error) {
+	// Each fix changes the text, so there is a limit in case fixes undo each other
+	for i := 0; i < 100; i++ {
+		var fixable *violation
+		for _, v := range p.lint(filename, filetext) {
+			if v.Fixable {
+				fixable = &v
+				break
+			}
+		}
+		if fixable == nil {
+			break
+		}
+		fixed, err := fixable.fix(filetext)
+		if err != nil {
+			return "", err
+		}
+		if fixed == filetext {
+			break
+		}
+		filetext = fixed
+	}
+	return filetext, nil
+}
+
+// lintResult is the result of "addinclude lint", for all files
+type lintResult struct {
+	Checked    int         `json:"checked"`
+	Violations []violation `json:"violations"`
+}
+
+// Write the result in the given format: text, json or sarif
+func (result *lintResult) write(w io.Writer, format string) error {
+	switch format {
+	case formatJSON:
+		if result.Violations == nil {
+			result.Violations = []violation{}
+		}
+		return writeIndentedJSON(w, result)
+	case formatSARIF:
+		var findings []finding
+		for _, v := range result.Violations {
+			findings = append(findings, finding{v.File, v.Line, v.Rule, v.Message})
+		}
+		return writeIndentedJSON(w, sarifLog(findings, policyRuleDescriptions))
+	}
+	for _, v := range result.Violations {
+		fmt.Fprintf(w, "%s:%d: %s: %s\n", v.File, v.Line, v.Rule, v.Message)
+	}
+	return nil
+}
+
+// Run "addinclude lint" with the given arguments, and return the exit code
+func runLint(args []string) int {
+	const (
+		policyText  = "the policy file (default: the closest .addinclude.conf)"
+		fixText     = "fix the violations that can be fixed automatically"
+		lintFmtText = "output format: text, json or sarif"
+	)
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: addinclude lint [--policy FILE] [--fix] [--format FORMAT] [path...]")
+		fmt.Println()
+		fmt.Println("Check the includes of the files against the rules in the policy file.")
+		fmt.Println()
+		fmt.Println("\t--policy FILE\t\t", policyText)
+		fmt.Println("\t--fix\t\t\t", fixText)
+		fmt.Println("\t--format FORMAT\t\t", lintFmtText)
+	}
+	var (
+		policyFile = fs.String("policy", "", policyText)
+		fix        = fs.Bool("fix", false, fixText)
+		format     = fs.String("format", formatText, lintFmtText)
+	)
+	fs.Parse(args)
+
+	switch *format {
+	case formatText, formatJSON, formatSARIF:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown output format for --format: %s\n", *format)
+		return 1
+	}
+
+	if *policyFile == "" {
+		var ok bool
+		if *policyFile, ok = findPolicyFile(); !ok {
+			fmt.Fprintf(os.Stderr, "Could not find %s. Use --policy to give the policy file.\n", configFilename)
+			return 1
+		}
+	}
+	p, err := readPolicy(*policyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the policy: %s\n", err)
+		return 1
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var (
+		result lintResult
+		code   int // the exit code for the first file that could not be checked or fixed
+	)
+	// Files that can not be read or fixed are reported, and the other files are still checked
+	failed := func(err error) {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		if code == 0 {
+			code = exitCode(err)
+		}
+	}
+	for _, filename := range expandPaths(paths) {
+		if *fix {
+			if _, err := editFile(filename, "", defaultOptions(), func(filetext string) (string, error) {
+				return p.fix(filename, filetext)
+			}); err != nil {
+				failed(err)
+				continue
+			}
+		}
+		opts := defaultOptions()
+		opts.dryRun = true
+		if _, err := editFile(filename, "", opts, func(filetext string) (string, error) {
+			result.Violations = append(result.Violations, p.lint(filename, filetext)...)
+			return filetext, nil
+		}); err != nil {
+			failed(err)
+			continue
+		}
+		result.Checked++
+	}
+	result.write(os.Stdout, *format)
+	if code != 0 {
+		return code
+	}
+	if len(result.Violations) > 0 {
+		return 5
+	}
+	return 0
+}
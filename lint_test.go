@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPolicy = `[require src/net/**]
+headers = "net_config.h"
+
+[forbid **/*.h]
+headers = <iostream>
+
+[forbid **]
+headers = "internal/*.h"
+except = src/internal/**
+
+[order]
+headers = <windows.h> <winsock2.h>
+`
+
+func readTestPolicy(t *testing.T) *policy {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, configFilename)
+	assert.Nil(t, os.WriteFile(filename, []byte(testPolicy), 0644))
+	p, err := readPolicy(filename)
+	assert.Nil(t, err)
+	return p
+}
+
+func TestHeaderPattern(t *testing.T) {
+	p := parseHeaderPattern("<iostream>")
+	assert.True(t, p.matches("iostream", false))
+	assert.False(t, p.matches("iostream", true))
+	assert.Equal(t, "<iostream>", p.String())
+
+	p = parseHeaderPattern("\"internal/*.h\"")
+	assert.True(t, p.matches("internal/x.h", true))
+	assert.False(t, p.matches("internal/x/y.h", true))
+	assert.True(t, p.isGlob())
+
+	p = parseHeaderPattern("stdio.h")
+	assert.True(t, p.matches("stdio.h", true))
+	assert.True(t, p.matches("stdio.h", false))
+	assert.Equal(t, "#include <stdio.h>", p.directive())
+}
+
+func TestReadPolicy(t *testing.T) {
+	p := readTestPolicy(t)
+	assert.Equal(t, 4, len(p.rules))
+	assert.Equal(t, "order", p.rules[3].kind)
+	assert.Equal(t, "**", p.rules[3].files)
+	assert.True(t, p.rules[2].appliesTo("src/net/a.c"))
+	assert.False(t, p.rules[2].appliesTo("src/internal/a.c"))
+}
+
+func TestLint(t *testing.T) {
+	p := readTestPolicy(t)
+	filename := filepath.Join(p.dir, "src", "net", "a.c")
+	text := "#include <winsock2.h>\n#include <windows.h>\n#include \"internal/x.h\"\n\nint x;\n"
+
+	violations := p.lint(filename, text)
+	assert.Equal(t, 3, len(violations))
+	assert.Equal(t, requiredIncludeRule, violations[0].Rule)
+	assert.Equal(t, forbiddenIncludeRule, violations[1].Rule)
+	assert.Equal(t, 3, violations[1].Line)
+	assert.Equal(t, includeOrderRule, violations[2].Rule)
+	assert.Equal(t, "<windows.h> must be included before <winsock2.h>", violations[2].Message)
+
+	fixed, err := p.fix(filename, text)
+	assert.Nil(t, err)
+	assert.Equal(t, "#include <windows.h>\n#include <winsock2.h>\n#include \"net_config.h\"\n\nint x;\n", fixed)
+	assert.Empty(t, p.lint(filename, fixed))
+
+	// The exception for src/internal
+	assert.Empty(t, p.lint(filepath.Join(p.dir, "src", "internal", "b.c"), "#include \"internal/x.h\"\n"))
+}
+
+func TestLintResult(t *testing.T) {
+	result := lintResult{Checked: 1, Violations: []violation{{File: "a.h", Line: 2, Rule: forbiddenIncludeRule, Message: "forbidden #include <iostream>"}}}
+	var buf bytes.Buffer
+	assert.Nil(t, result.write(&buf, formatText))
+	assert.Equal(t, "a.h:2: forbidden-include: forbidden #include <iostream>\n", buf.String())
+	buf.Reset()
+	assert.Nil(t, result.write(&buf, formatSARIF))
+	assert.Contains(t, buf.String(), `"ruleId": "forbidden-include"`)
+}
+
+func TestLintFixErrors(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"policy.conf": "[require **]\nheaders = <stdio.h>\n",
+		"a.c":         "// addinclude: off\n#include \"a.h\"\n",
+		"b.c":         "int y;\n",
+	})
+	// The file that can not be fixed is reported, and the other file is still fixed
+	code := runLint([]string{"--policy", filepath.Join(dir, "policy.conf"), "--fix", filepath.Join(dir, "a.c"), filepath.Join(dir, "b.c")})
+	assert.Equal(t, 4, code)
+	assert.Equal(t, "// addinclude: off\n#include \"a.h\"\n", readTestFile(t, filepath.Join(dir, "a.c")))
+	assert.Equal(t, "#include <stdio.h>\n\nint y;\n", readTestFile(t, filepath.Join(dir, "b.c")))
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// addIncludeCommand is the command for adding an include, with the document
+// URI and the include as arguments
+const addIncludeCommand = "addinclude.addInclude"
+
+// maxMessageLength is the largest Content-Length that is accepted
+const maxMessageLength = 64 << 20
+
+var errMessageTooLong = fmt.Errorf("the message is longer than %d bytes", maxMessageLength)
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// rpcMessage is an incoming JSON-RPC request, notification or response
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// The LSP types that are used, with only the fields that are needed
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspWorkspaceEdit struct {
+	Changes map[string][]lspTextEdit `json:"changes"`
+}
+
+type lspCommand struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments,omitempty"`
+}
+
+type lspCodeAction struct {
+	Title   string            `json:"title"`
+	Kind    string            `json:"kind"`
+	Edit    *lspWorkspaceEdit `json:"edit,omitempty"`
+	Command *lspCommand       `json:"command,omitempty"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspDiagnostic struct {
+	Range lspRange `json:"range"`
+}
+
+// Read a message with a Content-Length header, as used by the Language Server Protocol
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if pos := strings.Index(header, ":"); pos != -1 && strings.EqualFold(header[:pos], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(header[pos+1:])); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", header)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length")
+	}
+	if length > maxMessageLength {
+		// Skip the body, so that the next message can be read
+		if _, err := io.CopyN(ioutil.Discard, r, int64(length)); err != nil {
+			return nil, err
+		}
+		return nil, errMessageTooLong
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// Write a message with a Content-Length header
+func writeMessage(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	body := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	_, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// Convert a byte offset in the text to an LSP position, where the character
+// is counted in UTF-16 code units
+func positionAt(text string, offset int) lspPosition {
+	lines := splitLines(text)
+	offsets := lineOffsets(lines)
+	for i := range lines {
+		if offset < offsets[i+1] || i == len(lines)-1 {
+			column := min(offset-offsets[i], len(lines[i].text))
+			return lspPosition{i, len(utf16.Encode([]rune(lines[i].text[:column])))}
+		}
+	}
+	return lspPosition{}
+}
+
+// Convert an LSP position to a byte offset in the text
+func offsetAt(text string, pos lspPosition) int {
+	lines := splitLines(text)
+	if pos.Line >= len(lines) {
+		return len(text)
+	}
+	offset := lineOffsets(lines)[pos.Line]
+	units := 0
+	for i, r := range lines[pos.Line].text {
+		if units >= pos.Character {
+			return offset + i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return offset + len(lines[pos.Line].text)
+}
+
+// Return the path of a file:// URI
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
+
+// Compute the edits for adding the include to the given text, with the same
+// placement as when adding it to a file
+func includeEdits(filename, filetext, include string, opts *Options) ([]textEdit, error) {
+	changed, err := addIncludeToText(filename, filetext, include, opts)
+	if err != nil {
+		return nil, err
+	}
+	return computeEdits(filetext, changed), nil
+}
+
+// lspServer is a Language Server Protocol server that offers code actions
+// and a command for adding includes
+type lspServer struct {
+	in      *bufio.Reader
+	out     io.Writer
+	buffers map[string]string // the text of the open documents, by URI
+	nextID  int
+	stopped bool
+}
+
+// Create a new LSP server that reads from r and writes to w
+func newLSPServer(r io.Reader, w io.Writer) *lspServer {
+	return &lspServer{in: bufio.NewReader(r), out: w, buffers: make(map[string]string)}
+}
+
+// Serve requests until "exit" is received or the input ends
+func (s *lspServer) serve() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		} else if err == errMessageTooLong {
+			s.reply(nil, nil, &rpcError{rpcInvalidRequest, err.Error()})
+			continue
+		} else if err != nil {
+			return err
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.reply(nil, nil, &rpcError{rpcParseError, err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if msg.Method == "" {
+			// A response to a request from the server, like workspace/applyEdit
+			continue
+		}
+		result, rpcErr := s.handle(msg.Method, msg.Params)
+		if len(msg.ID) > 0 {
+			s.reply(msg.ID, result, rpcErr)
+		}
+	}
+}
+
+// Reply to a request with a result or an error
+func (s *lspServer) reply(id json.RawMessage, result interface{}, rpcErr *rpcError) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	if rpcErr != nil {
+		return writeMessage(s.out, rpcErrorResponse{"2.0", id, rpcErr})
+	}
+	return writeMessage(s.out, rpcResponse{"2.0", id, result})
+}
+
+// Send a request to the client
+func (s *lspServer) request(method string, params interface{}) error {
+	s.nextID++
+	return writeMessage(s.out, rpcRequest{"2.0", s.nextID, method, params})
+}
+
+// Handle a request or notification, and return the result for requests
+func (s *lspServer) handle(method string, params json.RawMessage) (interface{}, *rpcError) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // the full text is sent on each change
+				"codeActionProvider":     true,
+				"executeCommandProvider": map[string]interface{}{"commands": []string{addIncludeCommand}},
+			},
+			"serverInfo": map[string]string{"name": "addinclude", "version": versionString[len("addinclude "):]},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.stopped = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		s.buffers[p.TextDocument.URI] = p.TextDocument.Text
+		return nil, nil
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   lspTextDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.buffers[p.TextDocument.URI] = p.ContentChanges[n-1].Text
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		delete(s.buffers, p.TextDocument.URI)
+		return nil, nil
+	case "textDocument/codeAction":
+		var p struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+			Range        lspRange        `json:"range"`
+			Context      struct {
+				Diagnostics []lspDiagnostic `json:"diagnostics"`
+			} `json:"context"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		return s.codeActions(p.TextDocument.URI, p.Range, p.Context.Diagnostics), nil
+	case "workspace/executeCommand":
+		var p struct {
+			Command   string   `json:"command"`
+			Arguments []string `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		if p.Command != addIncludeCommand || len(p.Arguments) != 2 {
+			return nil, &rpcError{rpcInvalidParams, "expected " + addIncludeCommand + " with a document URI and an include"}
+		}
+		// A bad include is an error in the arguments, and should not stop the server
+		uri, include := p.Arguments[0], p.Arguments[1]
+		if _, err := documentOptions(uri).includeDirective(uriToPath(uri), include); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		edit, err := s.addIncludeEdit(uri, include)
+		if err != nil {
+			return nil, &rpcError{rpcInternalError, err.Error()}
+		}
+		if edit != nil {
+			s.request("workspace/applyEdit", map[string]interface{}{"label": "Add #include", "edit": edit})
+		}
+		return nil, nil
+	}
+	if strings.HasPrefix(method, "$/") {
+		// Optional notifications, like $/cancelRequest, can be ignored
+		return nil, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "unknown method: " + method}
+}
+
+// Return the options for the document with the given URI
+func documentOptions(uri string) *Options {
+	var (
+		opts     = defaultOptions()
+		filename = uriToPath(uri)
+		flags, _ = compileFlagsFor(filename, "")
+	)
+	opts.cppStyle = isCppFile(filename, flags)
+	opts.useCompileFlags(flags)
+	return opts
+}
+
+// Compute the workspace edit for adding the include to the open document with
+// the given URI. Returns nil if the header is already included.
+func (s *lspServer) addIncludeEdit(uri, include string) (*lspWorkspaceEdit, error) {
+	text, ok := s.buffers[uri]
+	if !ok {
+		return nil, fmt.Errorf("%s is not open", uri)
+	}
+	edits, err := includeEdits(uriToPath(uri), text, include, documentOptions(uri))
+	if err != nil || len(edits) == 0 {
+		return nil, err
+	}
+	var textEdits []lspTextEdit
+	for _, edit := range edits {
+		textEdits = append(textEdits, lspTextEdit{
+			lspRange{positionAt(text, edit.Offset), positionAt(text, edit.Offset+edit.Length)},
+			edit.Text,
+		})
+	}
+	return &lspWorkspaceEdit{map[string][]lspTextEdit{uri: textEdits}}, nil
+}
+
+// Find the code actions for adding includes for the identifiers in the range
+// and in the diagnostics. When the header for an identifier is known, the
+// action has the edit, unless the header is already included. Otherwise, the
+// action runs the command for adding <identifier.h>, which the client may
+// let the user change.
+func (s *lspServer) codeActions(uri string, r lspRange, diagnostics []lspDiagnostic) []lspCodeAction {
+	actions := []lspCodeAction{}
+	text, ok := s.buffers[uri]
+	if !ok {
+		return actions
+	}
+	ranges := []lspRange{r}
+	for _, d := range diagnostics {
+		ranges = append(ranges, d.Range)
+	}
+	var (
+		opts = documentOptions(uri)
+		seen = make(map[string]bool)
+	)
+	for _, r := range ranges {
+		identifier := identifierAt(text, offsetAt(text, r.Start))
+		header, ok := headerForSymbol(identifier, opts.cppStyle)
+		if !ok {
+			if identifier == "" || keywords[identifier] || seen[identifier] {
+				continue
+			}
+			seen[identifier] = true
+			if _, err := opts.includeDirective(uriToPath(uri), identifier); err != nil {
+				continue
+			}
+			actions = append(actions, lspCodeAction{
+				Title:   "Add #include…",
+				Kind:    "quickfix",
+				Command: &lspCommand{"Add #include…", addIncludeCommand, []interface{}{uri, identifier}},
+			})
+			continue
+		}
+		if seen[header] {
+			continue
+		}
+		seen[header] = true
+		include := incl + " <" + header + ">"
+		edit, err := s.addIncludeEdit(uri, include)
+		if err != nil || edit == nil {
+			continue
+		}
+		actions = append(actions, lspCodeAction{
+			Title: "Add " + include + " for " + identifier,
+			Kind:  "quickfix",
+			Edit:  edit,
+		})
+	}
+	return actions
+}
+
+// Run "addinclude lsp", which serves the Language Server Protocol on stdin
+// and stdout, and return the exit code
+func runLSP(args []string) int {
+	if len(args) > 0 {
+		fmt.Println("Usage: addinclude lsp")
+		fmt.Println()
+		fmt.Println("Serve the Language Server Protocol on stdin and stdout.")
+		return 1
+	}
+	s := newLSPServer(os.Stdin, os.Stdout)
+	if err := s.serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if !s.stopped {
+		// The client exited without a shutdown request
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// Run the LSP server with the given messages from a scripted client, and
+// return the messages from the server
+func runScriptedClient(t *testing.T, messages ...interface{}) []map[string]interface{} {
+	var in, out bytes.Buffer
+	for _, msg := range messages {
+		assert.Nil(t, writeMessage(&in, msg))
+	}
+	assert.Nil(t, newLSPServer(&in, &out).serve())
+	var replies []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var reply map[string]interface{}
+		assert.Nil(t, json.Unmarshal(body, &reply))
+		replies = append(replies, reply)
+	}
+	return replies
+}
+
+func request(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notification(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+func TestLSP(t *testing.T) {
+	const uri = "file:///tmp/project/main.c"
+	replies := runScriptedClient(t,
+		request(1, "initialize", map[string]interface{}{}),
+		notification("initialized", map[string]interface{}{}),
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "text": "int main() {}\n"},
+		}),
+		// The buffer is changed before it is saved
+		notification("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri},
+			"contentChanges": []interface{}{map[string]interface{}{"text": "#include <string.h>\n\nint main() {\n  printf(\"hi\");\n  frobnicate();\n}\n"}},
+		}),
+		request(2, "textDocument/codeAction", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"range":        map[string]interface{}{"start": map[string]int{"line": 3, "character": 4}, "end": map[string]int{"line": 3, "character": 4}},
+			"context": map[string]interface{}{"diagnostics": []interface{}{
+				map[string]interface{}{"range": map[string]interface{}{"start": map[string]int{"line": 4, "character": 2}, "end": map[string]int{"line": 4, "character": 12}}},
+				map[string]interface{}{"range": map[string]interface{}{"start": map[string]int{"line": 2, "character": 0}, "end": map[string]int{"line": 2, "character": 3}}},
+			}},
+		}),
+		request(3, "workspace/executeCommand", map[string]interface{}{
+			"command": addIncludeCommand, "arguments": []string{uri, "stdlib"},
+		}),
+		request(4, "shutdown", nil),
+		notification("exit", nil),
+	)
+	assert.Equal(t, 5, len(replies))
+
+	capabilities := replies[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	assert.Equal(t, true, capabilities["codeActionProvider"])
+
+	// frobnicate is not in the symbol table, and int is a keyword
+	actions := replies[1]["result"].([]interface{})
+	assert.Equal(t, 2, len(actions))
+	action := actions[0].(map[string]interface{})
+	assert.Equal(t, "Add #include <stdio.h> for printf", action["title"])
+	edits := action["edit"].(map[string]interface{})["changes"].(map[string]interface{})[uri].([]interface{})
+	edit := edits[0].(map[string]interface{})
+	assert.Equal(t, "#include <stdio.h>\n", edit["newText"])
+	assert.Equal(t, map[string]interface{}{"line": 1.0, "character": 0.0}, edit["range"].(map[string]interface{})["start"])
+	command := actions[1].(map[string]interface{})["command"].(map[string]interface{})
+	assert.Equal(t, addIncludeCommand, command["command"])
+	assert.Equal(t, []interface{}{uri, "frobnicate"}, command["arguments"])
+
+	// The command makes the server ask the client to apply the edit
+	assert.Equal(t, "workspace/applyEdit", replies[2]["method"])
+	params := replies[2]["params"].(map[string]interface{})
+	edits = params["edit"].(map[string]interface{})["changes"].(map[string]interface{})[uri].([]interface{})
+	assert.Equal(t, "#include <stdlib.h>\n", edits[0].(map[string]interface{})["newText"])
+	assert.Equal(t, 3.0, replies[3]["id"])
+	assert.Equal(t, 4.0, replies[4]["id"])
+}
+
+func TestLSPErrors(t *testing.T) {
+	const uri = "file:///tmp/project/main.c"
+	replies := runScriptedClient(t,
+		request(1, "textDocument/unknown", nil),
+		request(2, "workspace/executeCommand", map[string]interface{}{"command": addIncludeCommand, "arguments": []string{"file:///x.c", "stdio"}}),
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "text": "int main() {}\n"},
+		}),
+		request(3, "workspace/executeCommand", map[string]interface{}{"command": addIncludeCommand, "arguments": []string{uri, "a b c"}}),
+		// The server is still running after an unusual include
+		request(4, "shutdown", nil),
+	)
+	assert.Equal(t, 4, len(replies))
+	assert.Equal(t, float64(rpcMethodNotFound), replies[0]["error"].(map[string]interface{})["code"])
+	assert.Contains(t, replies[1]["error"].(map[string]interface{})["message"], "not open")
+	assert.Equal(t, float64(rpcInvalidParams), replies[2]["error"].(map[string]interface{})["code"])
+	assert.Contains(t, replies[2]["error"].(map[string]interface{})["message"], "Unusual include")
+	assert.Equal(t, 4.0, replies[3]["id"])
+	assert.Nil(t, replies[3]["error"])
+}
+
+func TestLSPMessageTooLong(t *testing.T) {
+	var in, out bytes.Buffer
+	fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n", maxMessageLength+1)
+	in.Write(make([]byte, maxMessageLength+1))
+	assert.Nil(t, writeMessage(&in, request(1, "shutdown", nil)))
+	assert.Nil(t, newLSPServer(&in, &out).serve())
+
+	// The long message gets an error, and the next message is read as usual
+	r := bufio.NewReader(&out)
+	var reply map[string]interface{}
+	body, err := readMessage(r)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(body, &reply))
+	assert.Equal(t, float64(rpcInvalidRequest), reply["error"].(map[string]interface{})["code"])
+	body, err = readMessage(r)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(body, &reply))
+	assert.Equal(t, 1.0, reply["id"])
+
+	// A message that claims to be too long, and then ends, is an error
+	_, err = readMessage(bufio.NewReader(strings.NewReader("Content-Length: 999999999999\r\n\r\n{}")))
+	assert.NotNil(t, err)
+}
+
+func TestPositions(t *testing.T) {
+	text := "ab\r\nçd😀e\nf"
+	assert.Equal(t, lspPosition{0, 2}, positionAt(text, 2))
+	assert.Equal(t, lspPosition{1, 0}, positionAt(text, 4))
+	// ç is 2 bytes and 1 UTF-16 unit, and 😀 is 4 bytes and 2 UTF-16 units
+	assert.Equal(t, lspPosition{1, 4}, positionAt(text, 11))
+	assert.Equal(t, 11, offsetAt(text, lspPosition{1, 4}))
+	assert.Equal(t, lspPosition{2, 1}, positionAt(text, len(text)))
+	assert.Equal(t, len(text), offsetAt(text, lspPosition{5, 0}))
+}
+
+func TestIdentifierAt(t *testing.T) {
+	text := "  std::vector<int> v; printf(x);"
+	assert.Equal(t, "std::vector", identifierAt(text, 4))
+	assert.Equal(t, "printf", identifierAt(text, 25))
+	header, ok := headerForSymbol("std::vector", true)
+	assert.True(t, ok)
+	assert.Equal(t, "vector", header)
+	_, ok = headerForSymbol("std::vector", false)
+	assert.False(t, ok)
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -12,11 +15,19 @@ const (
 	versionString = "addinclude 1.2.0"
 	ifdef         = "#ifdef"
 	ifndef        = "#ifndef"
+	ifdirective   = "#if"
 	incl          = "#include"
+	scopeAuto     = "auto"
+	scopeTop      = "top"
+	scopeOutside  = "outside"
 	dosEOL        = "\r\n"
 	unixEOL       = "\n"
+	macEOL        = "\r"
 )
 
+// ifdefs are the directives that start a conditional, except for #ifndef
+var ifdefs = []string{ifdef, ifdirective}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -24,13 +35,67 @@ func min(a, b int) int {
 	return b
 }
 
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Options holds the settings for how an include is added to a file
+type Options struct {
+	fixInclude    bool
+	atTop         bool
+	cppStyle      bool
+	addMainHeader bool
+	mainRegex     string
+	finalNewline  bool
+	eol           string // "lf", "crlf", "native" or "keep"
+	encoding      string // "auto" or one of the supported encodings
+	scope         string // "auto", "top" or "outside"
+	macros        *macroSet
+	inBranch      string // a branch selector, like "defined(_WIN32)" or "else:HAVE_FOO"
+	platform      bool
+	defines       []string // feature test macros to add, as NAME or NAME=VALUE
+	explain       bool
+	dryRun        bool     // don't write the file
+	includeDirs   []string // -I directories
+	systemDirs    []string // -isystem directories
+	quoteDirs     []string // -iquote directories
+	autoStyle     bool     // use quotes or angle brackets depending on where the header is found
+	noStdInc      bool     // don't search the default system directories
+	verify        bool     // warn if the header can not be found
+	strict        bool     // fail if the header can not be found
+}
+
+// Return the default options, as when no flags are given
+func defaultOptions() *Options {
+	return &Options{
+		fixInclude: true,
+		mainRegex:  defaultMainRegex,
+		eol:        "keep",
+		encoding:   encodingAuto,
+		scope:      scopeAuto,
+		macros:     newMacroSet(),
+	}
+}
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (sl *stringList) String() string     { return strings.Join(*sl, ",") }
+func (sl *stringList) Set(s string) error { *sl = append(*sl, s); return nil }
+
 // SourceCode represents the text in a C source file
 type SourceCode struct {
 	text           string
 	newline        string
+	lines          []line
+	lineStarts     []int
 	memoHasIfdef   bool
 	memoHasIfndef  bool
 	memoHasInclude bool
+	explain        *explanation // records the placement decisions, if not nil
 }
 
 // Create a new SourceCode struct
@@ -40,36 +105,61 @@ func newSourceCode(text string) *SourceCode {
 	return source
 }
 
-func (src *SourceCode) get() string                  { return src.text }
-func (src *SourceCode) getNewline() string           { return src.newline }
-func (src *SourceCode) has(text string) bool         { return strings.Contains(src.text, text) }
-func (src *SourceCode) first(text string) int        { return strings.Index(src.text, text) }
-func (src *SourceCode) hasIfdef() bool               { return src.memoHasIfdef }
-func (src *SourceCode) hasIfndef() bool              { return src.memoHasIfndef }
-func (src *SourceCode) hasInclude() bool             { return src.memoHasInclude }
-func (src *SourceCode) firstIfdef() int              { return src.first(ifdef) }
-func (src *SourceCode) firstIfndef() int             { return src.first(ifndef) }
-func (src *SourceCode) firstInclude() int            { return src.first(incl) }
-func (src *SourceCode) nextInclude(pos int) int      { return strings.Index(src.text[pos+1:], incl) }
-func (src *SourceCode) firstIncludeAfterIfdef() int  { return src.firstIncludeAfterWord(ifdef) }
-func (src *SourceCode) firstIncludeAfterIfndef() int { return src.firstIncludeAfterWord(ifndef) }
-func (src *SourceCode) theRest(pos int) *SourceCode  { return newSourceCode(src.text[pos:]) }
+func (src *SourceCode) get() string                       { return src.text }
+func (src *SourceCode) getNewline() string                { return src.newline }
+func (src *SourceCode) has(text string) bool              { return strings.Contains(src.text, text) }
+func (src *SourceCode) first(text string) int             { return strings.Index(src.text, text) }
+func (src *SourceCode) hasIfdef() bool                    { return src.memoHasIfdef }
+func (src *SourceCode) hasIfndef() bool                   { return src.memoHasIfndef }
+func (src *SourceCode) hasInclude() bool                  { return src.memoHasInclude }
+func (src *SourceCode) firstIfdef() int                   { return src.firstDirective(ifdefs...) }
+func (src *SourceCode) firstIfndef() int                  { return src.firstDirective(ifndef) }
+func (src *SourceCode) firstInclude() int                 { return src.firstDirective(incl) }
+func (src *SourceCode) hasDirective(words ...string) bool { return src.firstDirective(words...) != -1 }
+func (src *SourceCode) firstIncludeAfterIfdef() int       { return src.firstIncludeAfterWord(ifdefs...) }
+func (src *SourceCode) firstIncludeAfterIfndef() int      { return src.firstIncludeAfterWord(ifndef) }
+func (src *SourceCode) theRest(pos int) *SourceCode       { return newSourceCode(src.text[pos:]) }
 
 func (src *SourceCode) set(text string) {
 	src.text = text
+	src.lines = splitLines(text)
 	src.newline = src.discoverNewline()
+	src.lineStarts = make([]int, len(src.lines))
+	pos := 0
+	for i, l := range src.lines {
+		src.lineStarts[i] = pos
+		pos += len(l.text) + len(l.eol)
+	}
 	// memoization
-	src.memoHasIfdef = src.has(ifdef)
-	src.memoHasIfndef = src.has(ifndef)
-	src.memoHasInclude = src.has(incl)
+	src.memoHasIfdef = src.hasDirective(ifdefs...)
+	src.memoHasIfndef = src.hasDirective(ifndef)
+	src.memoHasInclude = src.hasDirective(incl)
+}
+
+// Find the start of the first line with one of the given directives, like "#include".
+// Whitespace is allowed before and after the "#", as for the C preprocessor.
+func (src *SourceCode) firstDirective(words ...string) int {
+	for i, l := range src.lines {
+		name := directiveName(l.text)
+		for _, word := range words {
+			if name != "" && name == strings.TrimPrefix(word, "#") {
+				return src.lineStarts[i]
+			}
+		}
+	}
+	return -1
 }
 
+// Find the most common line ending, or \n if there are no line endings
 func (src *SourceCode) discoverNewline() string {
-	// If there is a \r\n, assume it's DOS/Windows line endings
-	if strings.Contains(src.text, dosEOL) {
-		return dosEOL
+	counts := lineEndingCounts(src.lines)
+	newline := unixEOL
+	for _, eol := range []string{dosEOL, macEOL} {
+		if counts[eol] > counts[newline] {
+			newline = eol
+		}
 	}
-	return unixEOL
+	return newline
 }
 
 func (src *SourceCode) hasIfdefBefore(pos int) bool {
@@ -77,14 +167,14 @@ func (src *SourceCode) hasIfdefBefore(pos int) bool {
 	return (found != -1) && (found < pos)
 }
 
-func (src *SourceCode) firstIncludeAfterWord(word string) int {
-	if !src.hasInclude() && !src.has(word) {
+func (src *SourceCode) firstIncludeAfterWord(words ...string) int {
+	if !src.hasInclude() && !src.hasDirective(words...) {
 		return 0
 	}
-	if src.hasInclude() && !src.has(word) {
+	if src.hasInclude() && !src.hasDirective(words...) {
 		return src.firstInclude()
 	}
-	pos := src.first(word)
+	pos := src.firstDirective(words...)
 	tail := src.theRest(pos)
 	if tail.hasInclude() {
 		return pos + tail.firstInclude()
@@ -92,14 +182,57 @@ func (src *SourceCode) firstIncludeAfterWord(word string) int {
 	return pos
 }
 
+// Finds the end of the line at the given position, excluding the line ending
 func (src *SourceCode) endofline(pos int) int {
-	// Finds the end of the line at the given position
-	tail := src.theRest(pos + 1)
-	npos := tail.first(src.newline)
-	if npos != -1 {
-		return pos + 1 + npos
+	if len(src.lines) == 0 {
+		return 0
 	}
-	return 0
+	i := src.lineIndex(pos)
+	return src.lineStarts[i] + len(src.lines[i].text)
+}
+
+// Return the index of the line that contains the given position
+func (src *SourceCode) lineIndex(pos int) int {
+	i := sort.Search(len(src.lineStarts), func(i int) bool { return src.lineStarts[i] > pos })
+	return max(i-1, 0)
+}
+
+// Return the index of the line that follows the line ending at the given
+// position, where 0 is the top of the file
+func (src *SourceCode) lineAfter(pos int) int {
+	if pos == 0 {
+		return 0
+	}
+	return src.lineIndex(pos) + 1
+}
+
+// includeLine is an #include directive and the position of the line it is on
+type includeLine struct {
+	start, end int // the start and end of the line, excluding the line ending
+	name       string
+	quoted     bool
+}
+
+// Find all #include lines that have an include name within quotes or brackets
+func (src *SourceCode) includeLines() []includeLine {
+	var includes []includeLine
+	for i, l := range src.lines {
+		if name, quoted, ok := parseInclude(l.text); ok {
+			start := src.lineStarts[i]
+			includes = append(includes, includeLine{start, start + len(l.text), name, quoted})
+		}
+	}
+	return includes
+}
+
+// Check if the given header is already included, with quotes or brackets
+func (src *SourceCode) includes(name string) bool {
+	for _, include := range src.includeLines() {
+		if include.name == name {
+			return true
+		}
+	}
+	return false
 }
 
 // Try to find an appropriate insertion position for new includes
@@ -121,6 +254,19 @@ func (src *SourceCode) findInsertPos() int {
 		n |= hasIfndef
 	}
 
+	if src.explain != nil {
+		var names []string
+		for i, name := range []string{"hasInclude", "hasIfdef", "hasIfndef"} {
+			if n&(1<<i) != 0 {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			names = append(names, "default")
+		}
+		src.explain.switchCase(strings.Join(names, "|"))
+	}
+
 	pos := 0
 	switch n {
 	case hasInclude:
@@ -138,13 +284,20 @@ func (src *SourceCode) findInsertPos() int {
 	case hasInclude | hasIfdef | hasIfndef:
 		pos = min(src.firstIncludeAfterIfdef(), src.firstIncludeAfterIfndef())
 	default:
+		// Place it below the leading comments and shebang line, if any
+		if end := src.preambleEnd(); end > 0 {
+			src.explain.candidateLine(src, "below the leading comments", end)
+			return src.endofline(src.lineStarts[end-1])
+		}
 		return 0
 	}
+	src.explain.candidateOffset(src, "the directive found by findInsertPos", pos)
+	src.explain.candidateOffset(src, "the end of that line", src.endofline(pos))
 	return src.endofline(pos)
 }
 
 // Try to expand include-strings (for instance, "stdin" becomes "#include <stdin.h>")
-func expandInclude(include string, cppStyle bool) string {
+func expandInclude(include string, cppStyle bool) (string, error) {
 
 	if !strings.Contains(include, " ") {
 		// Include is just a word
@@ -155,7 +308,7 @@ func expandInclude(include string, cppStyle bool) string {
 				include = include + ".h"
 			}
 			// Add brackets
-			return incl + " <" + include + ">"
+			return incl + " <" + include + ">", nil
 		}
 		// ...and does not need brackets
 		if !cppStyle && !strings.Contains(include, ".") {
@@ -164,7 +317,7 @@ func expandInclude(include string, cppStyle bool) string {
 			include = include[0:len(include)-1] + ".h" + bracketchar
 			//include = include + bracketchar
 		}
-		return incl + " " + include
+		return incl + " " + include, nil
 	}
 
 	// Include is two words?
@@ -179,72 +332,329 @@ func expandInclude(include string, cppStyle bool) string {
 		return expandInclude(tail, cppStyle)
 	}
 
-	// Quit
-	fmt.Fprintf(os.Stderr, "Unusual include: %s\n", include)
-	os.Exit(3)
-	return include
+	return "", fmt.Errorf("Unusual include: %s", include)
 }
 
-func addIncludeToFile(filename, include string, fixInclude, atTop, cppStyle bool) {
-	var (
-		source       SourceCode
-		fixedInclude string
-	)
-	if fixInclude {
-		fixedInclude = expandInclude(include, cppStyle)
-	} else {
-		fixedInclude = include
+// Insert the directive before the line at the given index, in the same style
+// as the surrounding #include directives, and return the new text
+func insertDirective(source *SourceCode, index int, directive string) string {
+	directive = restyleDirective(directive, directivePrefixNear(source.lines, index))
+	return joinLines(insertLine(source.lines, index, directive, source.getNewline()))
+}
+
+// Add the include to the text of the given file, and return the new text
+func addIncludeToText(filename, filetext, include string, opts *Options) (string, error) {
+	filetext, _, err := addIncludeExplained(filename, filetext, include, opts)
+	return filetext, err
+}
+
+// Add the include to the text of the given file, and return the new text and,
+// with opts.explain, an explanation of where the include was placed. The
+// explanation is nil if nothing was placed.
+func addIncludeExplained(filename, filetext, include string, opts *Options) (string, *explanation, error) {
+	var source SourceCode
+
+	source.set(filetext)
+	newline := source.getNewline()
+	original := filetext
+
+	if counts := lineEndingCounts(source.lines); len(counts) > 1 {
+		fmt.Fprintf(os.Stderr, "%s has mixed line endings: %s\n", filename, describeLineEndings(counts))
+	}
+
+	// Add feature test macros before the first include
+	for _, spec := range opts.defines {
+		var err error
+		if filetext, err = addDefine(filename, filetext, spec, opts); err != nil {
+			return "", nil, err
+		}
+		source.set(filetext)
 	}
 
+	// Add the related header as the first include, if it is missing
+	if opts.addMainHeader && isSourceFile(filename) {
+		if start, _ := source.mainHeader(filename, opts.mainRegex); start == -1 {
+			mainInclude := incl + " \"" + relatedHeader(filename) + "\""
+			index, err := source.mainHeaderPlacement(filename, opts)
+			if err != nil {
+				return "", nil, err
+			}
+			filetext = joinLines(insertLine(splitLines(filetext), index, mainInclude, newline))
+			source.set(filetext)
+		}
+	}
+
+	if include != "" {
+		fixedInclude, err := opts.includeDirective(filename, include)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// Headers that are already included are not added again
+		name, _, ok := parseInclude(fixedInclude)
+		if set := findPlatformSet(readConfig(filename).platformSets, name); opts.platform && ok && set != nil {
+			var err error
+			if filetext, err = addPlatformIncludes(filename, filetext, set, opts); err != nil {
+				return "", nil, err
+			}
+		} else if !ok || !source.reachable(opts).includes(name) {
+			// Check that the header exists before adding it
+			if _, quoted, _ := parseInclude(fixedInclude); ok && (opts.verify || opts.strict) {
+				if err := opts.verifyHeader(filename, name, quoted); err != nil && opts.strict {
+					return "", nil, err
+				} else if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+				}
+			}
+			// Set the placement position at the top, or at a suitable place
+			if opts.explain {
+				source.explain = &explanation{File: filename}
+			}
+			index, err := source.findPlacement(filename, opts)
+			if err != nil {
+				return "", nil, err
+			}
+			filetext = insertDirective(&source, index, fixedInclude)
+		}
+	}
+
+	// Only add a missing final newline if configured to do so, and if the file was changed
+	if insertFinalNewline, _ := editorConfigValue(filename, "insert_final_newline"); filetext != original && (opts.finalNewline || insertFinalNewline == "true") {
+		filetext = joinLines(ensureFinalNewline(splitLines(filetext), newline))
+	}
+
+	if eol := eolOption(opts.eol); eol != "" {
+		filetext = joinLines(normalizeLineEndings(splitLines(filetext), eol))
+	}
+
+	return filetext, source.explain, nil
+}
+
+// Return the include directive for the given include argument and file, like
+// "#include <stdio.h>" for "stdio", or "#include "util/str.h"" for the path
+// include/util/str.h when include is an include directory
+func (opts *Options) includeDirective(filename, include string) (string, error) {
+	directive := include
+	if path, ok := headerPath(include); ok && opts.fixInclude {
+		directive = opts.includeForPath(filename, path)
+	} else if opts.fixInclude {
+		var err error
+		if directive, err = expandInclude(include, opts.cppStyle); err != nil {
+			return "", err
+		}
+	}
+	if name, quoted, ok := parseInclude(directive); ok && opts.autoStyle {
+		directive = setDelimiters(directive, opts.quoteStyle(filename, name, quoted))
+	}
+	return directive, nil
+}
+
+// fileError is an error from editing a file, together with the exit code for it
+type fileError struct {
+	code int // 2 for errors when reading or writing the file, 3 for an unusual include, 4 for errors when changing the text
+	err  error
+}
+
+func (e *fileError) Error() string { return e.err.Error() }
+
+// Return the exit code for the given error, which is 4 unless it is a fileError
+func exitCode(err error) int {
+	if e, ok := err.(*fileError); ok {
+		return e.code
+	}
+	return 4
+}
+
+// Read and decode the given file, change the text, and encode and write it,
+// unless opts.dryRun is set. Returns a report of what was changed, for the
+// given include directive, which may be empty. The file is not written if
+// there is an error, or if it is unchanged.
+func editFile(filename, directive string, opts *Options, change func(filetext string) (string, error)) (*fileReport, error) {
 	filedata, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not read %s\n", filename)
-		os.Exit(2)
+		return nil, &fileError{2, fmt.Errorf("Could not read %s", filename)}
 	}
-	filetext := string(filedata)
-	source.set(filetext)
+	// The byte order mark is kept out of the text, so that nothing is placed before it
+	decoded, err := decodeText(filedata, opts.encoding)
+	if err != nil {
+		return nil, &fileError{2, fmt.Errorf("Could not decode %s: %s", filename, err)}
+	}
+	filetext, err := change(decoded.text)
+	if err != nil {
+		return nil, &fileError{4, err}
+	}
+	encoded, err := decoded.encode(filetext)
+	if err != nil {
+		return nil, &fileError{2, fmt.Errorf("Could not encode %s: %s", filename, err)}
+	}
+	if !opts.dryRun && !bytes.Equal(encoded, filedata) {
+		if err := ioutil.WriteFile(filename, encoded, 0); err != nil {
+			return nil, &fileError{2, fmt.Errorf("Could not write %s: %s", filename, err)}
+		}
+	}
+	return newFileReport(filename, directive, decoded.text, filetext, decoded), nil
+}
 
-	// Set the placement position at the top, or at a suitable place
-	pos := 0
-	if !atTop {
-		pos = source.findInsertPos()
+// Add the include to the given file, and return a report of what was changed
+func addIncludeToFile(filename, include string, opts *Options) (*fileReport, error) {
+	directive := ""
+	if include != "" {
+		var err error
+		if directive, err = opts.includeDirective(filename, include); err != nil {
+			return nil, &fileError{3, err}
+		}
+	}
+	var e *explanation
+	report, err := editFile(filename, directive, opts, func(filetext string) (string, error) {
+		changed, explained, err := addIncludeExplained(filename, filetext, include, opts)
+		e = explained
+		return changed, err
+	})
+	if err != nil {
+		return nil, err
 	}
+	report.explanation = e
+	return report, nil
+}
 
-	newline := source.getNewline()
-	newtext := filetext[:pos] + newline + fixedInclude + newline + filetext[pos:]
-	ioutil.WriteFile(filename, []byte(newtext), 0)
+// Split the arguments into filenames and an include, which is the last argument.
+// If the include is optional, the last argument is a filename if it is an
+// existing source file. Headers are taken as the include, since a path to a
+// header is a valid include.
+func splitArgs(args []string, includeOptional bool) ([]string, string, bool) {
+	if len(args) == 0 {
+		return nil, "", false
+	}
+	last := args[len(args)-1]
+	if includeOptional {
+		if fi, err := os.Stat(last); len(args) == 1 || (err == nil && !fi.IsDir() && isSourceFile(last)) {
+			return args, "", true
+		}
+	}
+	if len(args) < 2 {
+		return nil, "", false
+	}
+	return args[:len(args)-1], last, true
 }
 
 func main() {
 
 	const (
-		nofixText   = "don't change the include text"
-		topText     = "add the include at the top"
-		versionText = "show the current version"
-		cppText     = "don't add .h to the include name"
-		verboseText = "more verbose output"
-		helpText    = "this brief help"
+		nofixText    = "don't change the include text"
+		topText      = "add the include at the top"
+		versionText  = "show the current version"
+		cppText      = "don't add .h to the include name"
+		verboseText  = "more verbose output"
+		mainText     = "add the related header as the first include"
+		regexText    = "suffix regex for finding the related header"
+		finalText    = "add a final newline if it is missing"
+		eolText      = "line endings: lf, crlf, native or keep"
+		encodingText = "encoding: auto, utf-8, latin1, windows-1252, utf-16le or utf-16be"
+		scopeText    = "placement: auto, top (level) or outside (all conditionals)"
+		defineText   = "macro that is defined, when finding dead branches"
+		undefText    = "macro that is undefined, when finding dead branches"
+		branchText   = "place the include in the given conditional branch"
+		platformText = "add POSIX or Windows headers in an #ifdef _WIN32 block"
+		addDefText   = "add a #define before the first include"
+		explainText  = "explain why the include was placed where it was"
+		jsonText     = "output a JSON report of the changes, for each file"
+		checkText    = "list the files where the include is missing, without changing them"
+		checkFmtText = "output format for --check: text, json or sarif"
+		exportText   = "write the changes as clang-apply-replacements YAML, instead of changing the files"
+		cursorText   = "edit the buffer on stdin, and write it and the moved cursor (OFFSET or LINE:COL)"
+		incDirText   = "directory to search for headers"
+		sysDirText   = "system directory to search for headers"
+		noStdIncText = "don't search the default system directories, like /usr/include"
+		verifyText   = "warn if the header can not be found in the include paths (the default)"
+		noVerifyText = "do not check that the header can be found"
+		strictText   = "fail if the header can not be found in the include paths"
+		quoteDirText = "directory to search for headers in quotes"
+		autoText     = "use quotes or angle brackets depending on where the header is found"
+		ccText       = "compile_commands.json file or directory, instead of searching for it"
+		noCCText     = "do not use compile_commands.json"
+		helpText     = "this brief help"
 	)
 
+	// Subcommands have their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "apply":
+			os.Exit(runApply(os.Args[2:]))
+		case "lsp":
+			os.Exit(runLSP(os.Args[2:]))
+		case "normalize":
+			os.Exit(runNormalize(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = func() {
 		fmt.Println(versionString)
 		fmt.Println()
 		fmt.Println("Add an include statement to a C header- or source file.")
 		fmt.Println()
 		fmt.Println("Arguments:")
-		fmt.Println("\tfilename, include")
+		fmt.Println("\tfilename [filename...] include")
 		fmt.Println("\t-n or --nofix\t\t", nofixText)
 		fmt.Println("\t-t or --top\t\t", topText)
 		fmt.Println("\t-v or --version\t\t", versionText)
 		fmt.Println("\t-+ or --c++\t\t", cppText)
 		fmt.Println("\t-V or --verbose\t\t", verboseText)
+		fmt.Println("\t-m or --add-main-header\t", mainText)
+		fmt.Println("\t--main-regex REGEX\t", regexText)
+		fmt.Println("\t--final-newline\t\t", finalText)
+		fmt.Println("\t--eol STYLE\t\t", eolText)
+		fmt.Println("\t--encoding ENCODING\t", encodingText)
+		fmt.Println("\t--scope SCOPE\t\t", scopeText)
+		fmt.Println("\t-D NAME[=VALUE]\t\t", defineText)
+		fmt.Println("\t-U NAME\t\t\t", undefText)
+		fmt.Println("\t--in-branch SELECTOR\t", branchText)
+		fmt.Println("\t-p or --platform\t", platformText)
+		fmt.Println("\t--define NAME[=VALUE]\t", addDefText)
+		fmt.Println("\t--explain\t\t", explainText)
+		fmt.Println("\t--json\t\t\t", jsonText)
+		fmt.Println("\t--check\t\t\t", checkText)
+		fmt.Println("\t--format FORMAT\t\t", checkFmtText)
+		fmt.Println("\t--export-fixes FILE\t", exportText)
+		fmt.Println("\t--cursor POSITION\t", cursorText)
+		fmt.Println("\t-I DIR\t\t\t", incDirText)
+		fmt.Println("\t-isystem DIR\t\t", sysDirText)
+		fmt.Println("\t-iquote DIR\t\t", quoteDirText)
+		fmt.Println("\t-nostdinc\t\t", noStdIncText)
+		fmt.Println("\t--verify\t\t", verifyText)
+		fmt.Println("\t--no-verify\t\t", noVerifyText)
+		fmt.Println("\t--strict\t\t", strictText)
+		fmt.Println("\t--auto-style\t\t", autoText)
+		fmt.Println("\t--compile-commands PATH\t", ccText)
+		fmt.Println("\t--no-compile-commands\t", noCCText)
 		fmt.Println("\t-h or --help\t\t", helpText)
 		fmt.Println()
+		fmt.Println("Subcommands:")
+		fmt.Println("\tlint [--policy FILE] [--fix] [path...]\t check the includes against a policy")
+		fmt.Println("\tapply [--dry-run] plan.yaml\t\t apply a batch edit plan, to all files or none")
+		fmt.Println("\tlsp\t\t\t\t\t serve the Language Server Protocol on stdin and stdout")
+		fmt.Println("\tnormalize [--check] [-iquote DIR] path...\t use quotes or angle brackets as with --auto-style")
+		fmt.Println()
 		fmt.Println("Examples:")
 		fmt.Println("\taddinclude file.h '#include <string.h>'")
 		fmt.Println("\taddinclude --top file.h stdlib")
 		fmt.Println("\taddinclude file.h '\"some.h\"'")
 		fmt.Println("\taddinclude file.cpp memory")
+		fmt.Println("\taddinclude --add-main-header file.c")
+		fmt.Println("\taddinclude --in-branch 'defined(_WIN32)' file.c windows")
+		fmt.Println("\taddinclude --in-branch else:_WIN32 file.c unistd")
+		fmt.Println("\taddinclude --platform file.c unistd")
+		fmt.Println("\taddinclude --define _GNU_SOURCE file.c")
+		fmt.Println("\taddinclude --json a.c b.c stdio")
+		fmt.Println("\taddinclude --check --format sarif src/net '\"net_config.h\"'")
+		fmt.Println("\taddinclude --export-fixes fixes.yaml src stdio")
+		fmt.Println("\taddinclude --cursor 12:5 file.c stdio < buffer.c")
+		fmt.Println("\taddinclude --strict -I include file.c '\"util.h\"'")
+		fmt.Println("\taddinclude --auto-style -iquote src file.c util")
+		fmt.Println("\taddinclude --compile-commands build file.c '\"config.h\"'")
+		fmt.Println("\taddinclude lint --fix src")
+		fmt.Println("\taddinclude apply plan.yaml")
 		fmt.Println()
 	}
 
@@ -272,16 +682,106 @@ func main() {
 
 		verboseShort = flag.Bool("V", false, verboseText)
 		verboseLong  = flag.Bool("verbose", false, verboseText)
+
+		mainShort = flag.Bool("m", false, mainText)
+		mainLong  = flag.Bool("add-main-header", false, mainText)
+
+		mainRegex = flag.String("main-regex", defaultMainRegex, regexText)
+
+		finalNewline = flag.Bool("final-newline", false, finalText)
+
+		eolStyle = flag.String("eol", "keep", eolText)
+
+		encoding = flag.String("encoding", encodingAuto, encodingText)
+
+		scope = flag.String("scope", scopeAuto, scopeText)
+
+		inBranch = flag.String("in-branch", "", branchText)
+
+		explain = flag.Bool("explain", false, explainText)
+
+		jsonOutput = flag.Bool("json", false, jsonText)
+
+		check = flag.Bool("check", false, checkText)
+
+		format = flag.String("format", formatText, checkFmtText)
+
+		exportFixes = flag.String("export-fixes", "", exportText)
+
+		cursor = flag.String("cursor", "", cursorText)
+
+		noStdInc = flag.Bool("nostdinc", false, noStdIncText)
+		verify   = flag.Bool("verify", true, verifyText)
+		noVerify = flag.Bool("no-verify", false, noVerifyText)
+		strict   = flag.Bool("strict", false, strictText)
+
+		autoStyle = flag.Bool("auto-style", false, autoText)
+
+		compileCommands   = flag.String("compile-commands", "", ccText)
+		noCompileCommands = flag.Bool("no-compile-commands", false, noCCText)
+
+		platformShort = flag.Bool("p", false, platformText)
+		platformLong  = flag.Bool("platform", false, platformText)
+
+		defines, undefines, addDefines, includeDirs, systemDirs, quoteDirs stringList
 	)
 
+	flag.Var(&defines, "D", defineText)
+	flag.Var(&undefines, "U", undefText)
+	flag.Var(&addDefines, "define", addDefText)
+	flag.Var(&includeDirs, "I", incDirText)
+	flag.Var(&systemDirs, "isystem", sysDirText)
+	flag.Var(&quoteDirs, "iquote", quoteDirText)
+
 	flag.Parse()
 
+	macros := newMacroSet()
+	for _, define := range defines {
+		macros.define(define)
+	}
+	for _, name := range undefines {
+		macros.undefine(name)
+	}
+
 	nofixFlag := *nofixLong || *nofixShort
 	topFlag := *topLong || *topShort
 	versionFlag := *versionLong || *versionShort
 	cppFlag := *cppLong || *cppShort
 	verboseFlag := *verboseLong || *verboseShort
 	helpFlag := *helpLong || *helpShort
+	mainFlag := *mainLong || *mainShort
+	platformFlag := *platformLong || *platformShort
+
+	switch *eolStyle {
+	case "lf", "crlf", "native", "keep":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown line ending style for --eol: %s\n", *eolStyle)
+		os.Exit(1)
+	}
+
+	switch *scope {
+	case scopeAuto, scopeTop, scopeOutside:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown scope for --scope: %s\n", *scope)
+		os.Exit(1)
+	}
+
+	if !isKnownEncoding(*encoding) {
+		fmt.Fprintf(os.Stderr, "Unknown encoding for --encoding: %s\n", *encoding)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case formatText, formatJSON, formatSARIF:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown output format for --format: %s\n", *format)
+		os.Exit(1)
+	}
+
+	if _, err := regexp.Compile(*mainRegex); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid regular expression for --main-regex: %s\n", err)
+		os.Exit(1)
+	}
 
 	args := flag.Args()
 
@@ -289,15 +789,115 @@ func main() {
 		flag.Usage()
 	} else if versionFlag {
 		fmt.Println(versionString)
-	} else if len(args) == 2 {
-		filename := flag.Arg(0)
-		include := flag.Arg(1)
-		cppFile := strings.HasSuffix(filename, ".cpp")
-		if verboseFlag {
-			fmt.Println("C++ mode:", cppFile || cppFlag)
+	} else if filenames, include, ok := splitArgs(args, mainFlag || len(addDefines) > 0); ok {
+		var (
+			result   checkResult
+			exported fixes
+		)
+		optionsFor := func(filename string) *Options {
+			var flags *compileFlags
+			if !*noCompileCommands {
+				var err error
+				if flags, err = compileFlagsFor(filename, *compileCommands); err != nil {
+					fmt.Fprintf(os.Stderr, "%s\n", err)
+					os.Exit(2)
+				}
+			}
+			opts := &Options{
+				fixInclude:    !nofixFlag,
+				atTop:         topFlag,
+				cppStyle:      isCppFile(filename, flags) || cppFlag,
+				addMainHeader: mainFlag,
+				mainRegex:     *mainRegex,
+				finalNewline:  *finalNewline,
+				eol:           *eolStyle,
+				encoding:      *encoding,
+				scope:         *scope,
+				macros:        macros,
+				inBranch:      *inBranch,
+				platform:      platformFlag,
+				defines:       addDefines,
+				explain:       *explain,
+				dryRun:        *check || *exportFixes != "",
+				includeDirs:   includeDirs,
+				systemDirs:    systemDirs,
+				noStdInc:      *noStdInc,
+				strict:        *strict,
+				quoteDirs:     quoteDirs,
+				autoStyle:     *autoStyle,
+			}
+			opts.useCompileFlags(flags)
+			opts.verify = *verify && !*noVerify
+			return opts
+		}
+		if *cursor != "" {
+			// The buffer is read from stdin, and the file does not need to exist
+			if len(filenames) != 1 {
+				fmt.Fprintf(os.Stderr, "--cursor needs a single filename\n")
+				os.Exit(1)
+			}
+			if *explain && !*jsonOutput {
+				// Both stdout and stderr are used for the buffer and the cursor
+				fmt.Fprintf(os.Stderr, "--explain can only be used with --cursor together with --json\n")
+				os.Exit(1)
+			}
+			c, err := parseCursor(*cursor)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			if err := editBuffer(filenames[0], include, c, optionsFor(filenames[0]), *jsonOutput, os.Stdin, os.Stdout, os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(4)
+			}
+			return
+		}
+		for _, filename := range expandPaths(filenames) {
+			opts := optionsFor(filename)
+			if verboseFlag {
+				fmt.Fprintln(os.Stderr, "C++ mode:", opts.cppStyle)
+			}
+			report, err := addIncludeToFile(filename, include, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(exitCode(err))
+			}
+			if report.explanation != nil {
+				// Keep the explanation out of machine readable output on stdout
+				w := os.Stdout
+				if *jsonOutput || *check {
+					w = os.Stderr
+				}
+				if err := report.explanation.write(w); err != nil {
+					fmt.Fprintf(os.Stderr, "Could not write the explanation: %s\n", err)
+					os.Exit(2)
+				}
+			}
+			if *exportFixes != "" {
+				exported.add(report)
+			}
+			if *check {
+				result.add(report)
+			} else if *jsonOutput {
+				report.writeJSON(os.Stdout)
+			}
+		}
+		if *exportFixes != "" {
+			if err := exported.writeFile(*exportFixes); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not write %s: %s\n", *exportFixes, err)
+				os.Exit(2)
+			}
+		}
+		if *check {
+			checkFormat := *format
+			if *jsonOutput {
+				checkFormat = formatJSON
+			}
+			result.write(os.Stdout, checkFormat)
+			if len(result.Missing) > 0 {
+				os.Exit(5)
+			}
 		}
-		// Notice the !
-		addIncludeToFile(filename, include, !nofixFlag, topFlag, cppFile || cppFlag)
 	} else {
 		missingArgs()
 	}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNewl(t *testing.T) {
@@ -69,6 +72,11 @@ func TestTestfile5(t *testing.T) {
 }
 
 func TestFixInclu(t *testing.T) {
+	expandInclude := func(include string, cppStyle bool) string {
+		directive, err := expandInclude(include, cppStyle)
+		assert.Nil(t, err)
+		return directive
+	}
 	assert.Equal(t, "#include <stdlib.h>", expandInclude("bolle stdlib", false))
 	assert.Equal(t, "#include <stdlib.h>", expandInclude("#include <stdlib.h>", false))
 	assert.Equal(t, "#include <stdlib.h>", expandInclude("include <stdlib.h>", false))
@@ -79,6 +87,13 @@ func TestFixInclu(t *testing.T) {
 	assert.Equal(t, "#include <memory>", expandInclude("memory", true))
 }
 
+func TestUnusualInclude(t *testing.T) {
+	_, err := expandInclude("a b c", false)
+	assert.NotNil(t, err)
+	_, err = addIncludeToText("x.c", "", "a b c", defaultOptions())
+	assert.NotNil(t, err)
+}
+
 func TestTestfile6(t *testing.T) {
 	testcontent := `#include "jeje.h"
 
@@ -92,3 +107,63 @@ func TestTestfile6(t *testing.T) {
 	source := newSourceCode(testcontent)
 	assert.Equal(t, 59, source.findInsertPos())
 }
+
+func TestSplitArgs(t *testing.T) {
+	filenames, include, ok := splitArgs([]string{"a.c", "b.c", "stdio"}, false)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a.c", "b.c"}, filenames)
+	assert.Equal(t, "stdio", include)
+
+	_, _, ok = splitArgs([]string{"a.c"}, false)
+	assert.False(t, ok)
+
+	filenames, include, ok = splitArgs([]string{"a.c"}, true)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a.c"}, filenames)
+	assert.Equal(t, "", include)
+
+	dir := t.TempDir()
+	source, header := filepath.Join(dir, "b.c"), filepath.Join(dir, "b.h")
+	assert.Nil(t, os.WriteFile(source, []byte{}, 0644))
+	assert.Nil(t, os.WriteFile(header, []byte{}, 0644))
+
+	// The last argument is a filename if it is an existing source file and the
+	// include is optional
+	filenames, include, ok = splitArgs([]string{"a.c", source}, true)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a.c", source}, filenames)
+	assert.Equal(t, "", include)
+
+	// An existing header is the include, since it may be a path to a header
+	filenames, include, ok = splitArgs([]string{"a.c", header}, true)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a.c"}, filenames)
+	assert.Equal(t, header, include)
+
+	// ...and so is any other existing file that is not a source file
+	filenames, include, ok = splitArgs([]string{"a.c", "main_test.go"}, true)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a.c"}, filenames)
+	assert.Equal(t, "main_test.go", include)
+}
+
+func TestEditFileUnchanged(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.c")
+	assert.Nil(t, os.WriteFile(filename, []byte("#include <stdio.h>\n"), 0644))
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.Nil(t, os.Chtimes(filename, past, past))
+
+	// The file is not written when the include is already there
+	report, err := addIncludeToFile(filename, "stdio", defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, actionSkipped, report.Action)
+	fi, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.True(t, fi.ModTime().Equal(past))
+
+	_, err = addIncludeToFile(filename, "stdlib", defaultOptions())
+	assert.Nil(t, err)
+	fi, err = os.Stat(filename)
+	assert.Nil(t, err)
+	assert.False(t, fi.ModTime().Equal(past))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultMainRegex is the suffix that may follow the stem of the related header,
+// in the same way as IncludeIsMainRegex for clang-format. With the default,
+// both foo.c and foo_test.c are related to "foo.h".
+const defaultMainRegex = "(_test)?$"
+
+var (
+	sourceExtensions = []string{".c", ".cc", ".cpp", ".cxx", ".c++", ".m", ".mm"}
+	headerExtensions = []string{".h", ".hpp", ".hh", ".hxx", ".h++"}
+)
+
+// Check if the given filename has one of the given extensions
+func hasExtension(filename string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the given filename is a C or C++ source file (and not a header)
+func isSourceFile(filename string) bool {
+	return hasExtension(filename, sourceExtensions)
+}
+
+// Return the filename without the directory and without the extension
+func stem(filename string) string {
+	base := filepath.Base(filename)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Check if the given include name (without delimiters) is the related header
+// of the given source file, using mainRegex as the allowed suffix of the source file stem
+func isMainHeader(sourceFilename, name, mainRegex string) bool {
+	if !hasExtension(name, headerExtensions) {
+		return false
+	}
+	re, err := regexp.Compile("^" + regexp.QuoteMeta(stem(name)) + "(?:" + mainRegex + ")")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(stem(sourceFilename))
+}
+
+// Find the #include line for the related header of the given source file.
+// Returns the start and end position of the line, or -1, -1 if there is none.
+func (src *SourceCode) mainHeader(filename, mainRegex string) (int, int) {
+	if !isSourceFile(filename) {
+		return -1, -1
+	}
+	for _, include := range src.includeLines() {
+		if include.quoted && isMainHeader(filename, include.name, mainRegex) {
+			return include.start, include.end
+		}
+	}
+	return -1, -1
+}
+
+// Find the related header of a source file, by looking for foo.h, foo.hpp
+// and include/foo.h next to foo.c. Falls back to foo.h if none are found.
+func relatedHeader(filename string) string {
+	var (
+		dir  = filepath.Dir(filename)
+		name = stem(filename)
+	)
+	for _, ext := range headerExtensions {
+		if _, err := os.Stat(filepath.Join(dir, name+ext)); err == nil {
+			return name + ext
+		}
+	}
+	// The include directory is assumed to be in the include path
+	for _, includeDir := range []string{"include", filepath.Join("..", "include")} {
+		for _, ext := range headerExtensions {
+			if _, err := os.Stat(filepath.Join(dir, includeDir, name+ext)); err == nil {
+				return name + ext
+			}
+		}
+	}
+	return name + ".h"
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIsMainHeader(t *testing.T) {
+	assert.True(t, isMainHeader("foo.c", "foo.h", defaultMainRegex))
+	assert.True(t, isMainHeader("src/foo.cpp", "include/foo.hpp", defaultMainRegex))
+	assert.True(t, isMainHeader("foo_test.c", "foo.h", defaultMainRegex))
+	assert.False(t, isMainHeader("foo.c", "foobar.h", defaultMainRegex))
+	assert.False(t, isMainHeader("foobar.c", "foo.h", defaultMainRegex))
+	assert.False(t, isMainHeader("foo.c", "foo.c", defaultMainRegex))
+	assert.True(t, isMainHeader("foo_unix.c", "foo.h", "(_unix|_win32)?$"))
+}
+
+func TestMainHeaderFirst(t *testing.T) {
+	testcontent := `#include "foo.h"
+#include <stdio.h>
+`
+	source := newSourceCode(testcontent)
+	start, end := source.mainHeader("foo.c", defaultMainRegex)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 16, end)
+	// The related header is never preceded by new includes, even with --top
+	index, err := source.findPlacement("foo.c", &Options{atTop: true, mainRegex: defaultMainRegex})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, index)
+	index, err = source.findPlacement("foo.h", &Options{atTop: true, mainRegex: defaultMainRegex})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, index)
+	start, _ = source.mainHeader("bar.c", defaultMainRegex)
+	assert.Equal(t, -1, start)
+}
+
+func TestMainHeaderDeadBranch(t *testing.T) {
+	// The related header is not placed in a branch that is never taken
+	opts := defaultOptions()
+	opts.addMainHeader = true
+	result, err := addIncludeToText("foo.c", "#if 0\n#include <x.h>\n#endif\n#include <stdio.h>\n", "", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#if 0\n#include <x.h>\n#endif\n#include \"foo.h\"\n#include <stdio.h>\n", result)
+}
@@ -0,0 +1,61 @@
+package main
+
+import "regexp"
+
+// markerRegexp matches marker comments, like "// addinclude: here" or
+// "/* addinclude: off */"
+var markerRegexp = regexp.MustCompile(`(?://|/\*)\s*addinclude:\s*(here|off|on)\b`)
+
+// Return the marker on the given line, "here", "off" or "on", or "" if there is none
+func marker(text string) string {
+	if m := markerRegexp.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Find which lines are in protected regions, from an "addinclude: off" marker
+// to an "addinclude: on" marker. A region without an "on" marker lasts to the
+// end of the file.
+func protectedLines(lines []line) []bool {
+	protected := make([]bool, len(lines))
+	off := false
+	for i, l := range lines {
+		switch marker(l.text) {
+		case "off":
+			off = true
+		case "on":
+			protected[i] = off
+			off = false
+		}
+		if off {
+			protected[i] = true
+		}
+	}
+	return protected
+}
+
+// Find the line index of the first "addinclude: here" marker that is not in a
+// protected region, or -1 if there is none
+func (src *SourceCode) hereMarker() int {
+	protected := protectedLines(src.lines)
+	for i, l := range src.lines {
+		if !protected[i] && marker(l.text) == "here" {
+			return i
+		}
+	}
+	return -1
+}
+
+// Move an insertion line index out of a protected region, to below the
+// "addinclude: on" marker. Returns false if the region has no end.
+func (src *SourceCode) unprotectedIndex(index int) (int, bool) {
+	protected := protectedLines(src.lines)
+	for index > 0 && index < len(protected) && protected[index-1] && protected[index] {
+		index++
+	}
+	if index == len(protected) && index > 0 && protected[index-1] && marker(src.lines[index-1].text) != "on" {
+		return index, false
+	}
+	return index, true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHereMarker(t *testing.T) {
+	testcontent := `#include <a.h>
+
+/* generated code follows */
+// addinclude: here
+int x;
+`
+	source := newSourceCode(testcontent)
+	assert.Equal(t, 3, source.hereMarker())
+	index, err := source.findPlacement("x.c", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, index)
+}
+
+func TestProtectedRegions(t *testing.T) {
+	testcontent := `/* addinclude: off */
+#include <generated.h>
+// addinclude: here
+/* addinclude: on */
+int x;
+`
+	source := newSourceCode(testcontent)
+	assert.Equal(t, []bool{true, true, true, true, false}, protectedLines(source.lines))
+	// The marker and the include in the protected region are ignored
+	assert.Equal(t, -1, source.hereMarker())
+	assert.False(t, source.live(&Options{}).includes("generated.h"))
+	// ...but the include still counts as included, so it is not added again
+	assert.True(t, source.reachable(defaultOptions()).includes("generated.h"))
+	result, err := addIncludeToText("x.c", testcontent, "<generated.h>", defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, testcontent, result)
+	index, err := source.findPlacement("x.c", &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, index)
+	// Positions inside the protected region are moved below it
+	index, _ = source.unprotectedIndex(2)
+	assert.Equal(t, 4, index)
+
+	// The related header is in a protected region that does not end
+	source = newSourceCode("// addinclude: off\n#include \"foo.h\"\n")
+	_, err = source.findPlacement("foo.c", &Options{mainRegex: defaultMainRegex})
+	assert.NotNil(t, err)
+}
+
+func TestMainHeaderProtected(t *testing.T) {
+	opts := defaultOptions()
+	opts.addMainHeader = true
+	// The include in the protected region is not used for placing the related header
+	result, err := addIncludeToText("bar.c", "// addinclude: off\n#include <x.h>\n// addinclude: on\n", "", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "#include \"bar.h\"\n\n// addinclude: off\n#include <x.h>\n// addinclude: on\n", result)
+	// The related header is placed outside of the protected region
+	result, err = addIncludeToText("bar.c", "// addinclude: off\n#include <x.h>\n// addinclude: on\n#include <a.h>\n", "", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "// addinclude: off\n#include <x.h>\n// addinclude: on\n#include \"bar.h\"\n#include <a.h>\n", result)
+}
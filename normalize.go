@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Use quotes or angle brackets for all include directives in the text,
+// depending on where the headers are found, as for --auto-style. Directives
+// in protected regions are not touched. Returns the text and the line
+// indexes of the changed directives.
+func normalizeIncludes(filename, filetext string, opts *Options) (string, []int) {
+	var (
+		lines     = splitLines(filetext)
+		protected = protectedLines(lines)
+		changed   []int
+	)
+	for i, l := range lines {
+		name, quoted, ok := parseInclude(l.text)
+		if !ok || protected[i] {
+			continue
+		}
+		if text := setDelimiters(l.text, opts.quoteStyle(filename, name, quoted)); text != l.text {
+			lines[i].text = text
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return filetext, nil
+	}
+	return joinLines(lines), changed
+}
+
+// Run "addinclude normalize" with the given arguments, and return the exit code
+func runNormalize(args []string) int {
+	const (
+		checkText    = "list the directives that would be changed, without changing the files"
+		incDirText   = "directory to search for headers"
+		sysDirText   = "system directory to search for headers"
+		quoteDirText = "directory to search for headers in quotes"
+		noStdIncText = "don't search the default system directories, like /usr/include"
+		cppText      = "search the C++ system directories"
+	)
+	fs := flag.NewFlagSet("normalize", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: addinclude normalize [--check] [-I DIR] [-isystem DIR] [-iquote DIR] path...")
+		fmt.Println()
+		fmt.Println("Use quotes for headers that are found next to the file or in -iquote directories,")
+		fmt.Println("and angle brackets for headers that are found in system directories.")
+		fmt.Println()
+		fmt.Println("\t--check\t\t\t", checkText)
+		fmt.Println("\t-I DIR\t\t\t", incDirText)
+		fmt.Println("\t-isystem DIR\t\t", sysDirText)
+		fmt.Println("\t-iquote DIR\t\t", quoteDirText)
+		fmt.Println("\t-nostdinc\t\t", noStdIncText)
+		fmt.Println("\t--c++\t\t\t", cppText)
+	}
+	var (
+		check                              = fs.Bool("check", false, checkText)
+		noStdInc                           = fs.Bool("nostdinc", false, noStdIncText)
+		cpp                                = fs.Bool("c++", false, cppText)
+		includeDirs, systemDirs, quoteDirs stringList
+	)
+	fs.Var(&includeDirs, "I", incDirText)
+	fs.Var(&systemDirs, "isystem", sysDirText)
+	fs.Var(&quoteDirs, "iquote", quoteDirText)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Needs a filename. Use --help for more info.\n")
+		return 1
+	}
+	var (
+		found bool
+		code  int // the exit code for the first file that could not be normalized
+	)
+	for _, filename := range expandPaths(fs.Args()) {
+		opts := defaultOptions()
+		opts.includeDirs, opts.systemDirs, opts.quoteDirs = includeDirs, systemDirs, quoteDirs
+		opts.noStdInc = *noStdInc
+		flags, _ := compileFlagsFor(filename, "")
+		opts.cppStyle = *cpp || isCppFile(filename, flags)
+		opts.useCompileFlags(flags)
+		opts.dryRun = *check
+		if _, err := editFile(filename, "", opts, func(filetext string) (string, error) {
+			changed, indexes := normalizeIncludes(filename, filetext, opts)
+			lines := splitLines(changed)
+			for _, i := range indexes {
+				found = true
+				if *check {
+					fmt.Printf("%s:%d: %s\n", filename, i+1, lines[i].text)
+				}
+			}
+			return changed, nil
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			if code == 0 {
+				code = exitCode(err)
+			}
+		}
+	}
+	if code != 0 {
+		return code
+	}
+	if *check && found {
+		return 5
+	}
+	return 0
+}
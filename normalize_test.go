@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeIncludes(t *testing.T) {
+	dir, opts := testIncludeDir(t, "src/local.h", "system/stdio.h")
+	opts.includeDirs = nil
+	opts.systemDirs = []string{filepath.Join(dir, "system")}
+	filename := filepath.Join(dir, "src", "a.c")
+
+	text := "#include <local.h>\n#include \"stdio.h\" // io\n#include <missing.h>\n// addinclude: off\n#include <local.h>\n// addinclude: on\n"
+	changed, indexes := normalizeIncludes(filename, text, opts)
+	assert.Equal(t, []int{0, 1}, indexes)
+	assert.Equal(t, "#include \"local.h\"\n#include <stdio.h> // io\n#include <missing.h>\n// addinclude: off\n#include <local.h>\n// addinclude: on\n", changed)
+
+	changed, indexes = normalizeIncludes(filename, changed, opts)
+	assert.Empty(t, indexes)
+}
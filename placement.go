@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Find the line index where new includes should be inserted in the given file,
+// taking the options and the related header of source files into account
+func (src *SourceCode) findPlacement(filename string, opts *Options) (int, error) {
+	src.explain.directives(src)
+	index := 0
+	if opts.inBranch != "" {
+		tree := parseConditionals(src.lines)
+		tree.evaluate(opts.macros)
+		branch := tree.findBranch(opts.inBranch)
+		if branch == nil {
+			return 0, fmt.Errorf("%s has no conditional branch that matches %q", filename, opts.inBranch)
+		}
+		if branch.dead {
+			fmt.Fprintf(os.Stderr, "Warning: the %s branch in %s is never taken\n", opts.inBranch, filename)
+		}
+		index = src.indexInBranch(branch)
+		src.explain.candidateLine(src, "in the branch selected with --in-branch", index)
+	} else if marker := src.hereMarker(); marker != -1 && !opts.atTop {
+		// The "addinclude: here" marker wins over the heuristics
+		index = marker
+		src.explain.candidateLine(src, "above the addinclude: here marker", index)
+	} else if !opts.atTop {
+		tree := parseConditionals(src.lines)
+		tree.evaluate(opts.macros)
+		live := src.live(opts)
+		live.explain = src.explain
+		index = live.lineAfter(live.findInsertPos())
+		src.explain.candidateLine(src, "after the line found by findInsertPos", index)
+		index = live.inScope(index, opts.scope)
+		src.explain.candidateLine(src, "in the "+opts.scope+" scope, without splitting lines", index)
+		var ok bool
+		if index, ok = tree.liveIndex(index); !ok {
+			fmt.Fprintf(os.Stderr, "Warning: the include can only be placed in a branch of %s that is never taken\n", filename)
+		}
+		src.explain.candidateLine(src, "outside of branches that are never taken", index)
+	} else {
+		src.explain.candidateLine(src, "at the top, because of --top", index)
+	}
+	// New includes are never placed above the related header
+	if start, _ := src.mainHeader(filename, opts.mainRegex); start != -1 && index <= src.lineIndex(start) {
+		index = src.lineIndex(start) + 1
+		src.explain.candidateLine(src, "below the related header", index)
+	}
+	// ...or above the feature test macros that are added with --define
+	for _, spec := range opts.defines {
+		name, _ := splitDefine(spec)
+		if i, _ := src.findDefine(name); i != -1 && index <= i {
+			index = i + 1
+			src.explain.candidateLine(src, "below the definition of "+name, index)
+		}
+	}
+	// Protected regions are never touched
+	index, ok := src.unprotectedIndex(index)
+	if !ok {
+		return 0, fmt.Errorf("the include can only be placed in a protected region of %s", filename)
+	}
+	src.explain.candidateLine(src, "outside of protected regions", index)
+	src.explain.result(src, index)
+	return index, nil
+}
+
+// Find the line index where the related header should be inserted, which is
+// above the first include, or where other includes would be placed
+func (src *SourceCode) mainHeaderPlacement(filename string, opts *Options) (int, error) {
+	index := 0
+	if !opts.atTop {
+		index = src.liveMainHeaderIndex(filename, opts)
+	}
+	// Protected regions are never touched
+	index, ok := src.unprotectedIndex(index)
+	if !ok {
+		return 0, fmt.Errorf("the related header can only be placed in a protected region of %s", filename)
+	}
+	return index, nil
+}
+
+// Find the line index above the first include, or where other includes would
+// be placed, outside of branches that are never taken
+func (src *SourceCode) liveMainHeaderIndex(filename string, opts *Options) int {
+	tree := parseConditionals(src.lines)
+	tree.evaluate(opts.macros)
+	live := src.live(opts)
+	index := 0
+	if includes := live.includeLines(); len(includes) > 0 {
+		index = live.lineIndex(includes[0].start)
+	} else {
+		index = live.inScope(live.lineAfter(live.findInsertPos()), opts.scope)
+	}
+	index, ok := tree.liveIndex(index)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Warning: the related header can only be placed in a branch of %s that is never taken\n", filename)
+	}
+	return index
+}
+
+// Return a copy of the source code where the lines in dead code, like #if 0,
+// are blank, so that includes there are not counted as already included
+func (src *SourceCode) reachable(opts *Options) *SourceCode {
+	return src.masked(parseConditionals(src.lines).evaluate(opts.macros))
+}
+
+// Return a copy of the source code where the lines in dead code and in
+// protected regions are blank, so that nothing is placed relative to them
+func (src *SourceCode) live(opts *Options) *SourceCode {
+	mask := parseConditionals(src.lines).evaluate(opts.macros)
+	for i, protected := range protectedLines(src.lines) {
+		mask[i] = mask[i] || protected
+	}
+	return src.masked(mask)
+}
+
+// Return a copy of the source code where the given lines are blank
+func (src *SourceCode) masked(mask []bool) *SourceCode {
+	lines := make([]line, len(src.lines))
+	for i, l := range src.lines {
+		if i < len(mask) && mask[i] {
+			l.text = ""
+		}
+		lines[i] = l
+	}
+	return newSourceCode(joinLines(lines))
+}
+
+// Move the given insertion line index to the given scope, which is "auto" for
+// where the index already is, "top" for the top level or inside the include guard,
+// or "outside" for outside of all conditionals. Conditionals are never split.
+func (src *SourceCode) inScope(index int, scope string) int {
+	tree := parseConditionals(src.lines)
+	for tree.splitsLine(index) {
+		index++
+	}
+	var inScope func(int) bool
+	switch scope {
+	case scopeTop:
+		inScope = tree.isTopLevel
+	case scopeOutside:
+		inScope = func(i int) bool { return tree.branchAt(i) == nil }
+	default:
+		return index
+	}
+	if inScope(index) {
+		return index
+	}
+	// Place it after the first #include in the scope, or above the conditional
+	for _, include := range src.includeLines() {
+		if i := src.lineIndex(include.start) + 1; inScope(i) {
+			return i
+		}
+	}
+	return tree.outermostAt(index, scope == scopeTop).start
+}
+
+// Find the insertion line index within the given branch, using the usual
+// placement, but only for the lines in the branch
+func (src *SourceCode) indexInBranch(branch *condBranch) int {
+	body := newSourceCode(joinLines(src.lines[branch.last+1 : branch.end]))
+	return branch.last + 1 + body.lineAfter(body.findInsertPos())
+}
+
+// Find the line index after the leading comments, like a license header, an
+// SPDX line, a Doxygen @file block or an editor modeline, and after a shebang
+// line. Only a doc comment, like /** ... */ or ///, that is directly followed
+// by code documents that code, and is not skipped.
+func (src *SourceCode) preambleEnd() int {
+	var (
+		end       = 0
+		docStart  = -1 // the line index of a doc comment that may belong to the code below it
+		inComment = false
+	)
+	for i, l := range src.lines {
+		if i == 0 && strings.HasPrefix(l.text, "#!") {
+			end = 1
+			continue
+		}
+		startsComment := !inComment
+		switch {
+		case !inComment && isBlank(l.text):
+			if docStart != -1 {
+				// A doc comment that is followed by a blank line documents the file
+				end, docStart = i, -1
+			}
+		case isBlankOrComment(l.text, &inComment):
+			if startsComment && isDocComment(l.text) {
+				if docStart == -1 {
+					docStart = i
+				}
+			} else if startsComment {
+				docStart = -1
+			}
+			if docStart == -1 {
+				end = i + 1
+			}
+		default:
+			if docStart != -1 && isDirective(l.text) {
+				end = i
+			}
+			return end
+		}
+	}
+	if docStart != -1 {
+		end = len(src.lines)
+	}
+	return end
+}
+
+// Check if the line starts a doc comment, like /** or ///
+func isDocComment(text string) bool {
+	text = strings.TrimSpace(text)
+	for _, prefix := range []string{"/**", "/*!", "///", "//!"} {
+		if strings.HasPrefix(text, prefix) && !strings.HasPrefix(text, "/**/") {
+			return true
+		}
+	}
+	return false
+}
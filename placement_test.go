@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const platformTestContent = `#include <stdio.h>
+
+#ifdef _WIN32
+#include <windows.h>
+#else
+#include <unistd.h>
+#endif
+
+#if defined( HAVE_FOO )
+#endif
+`
+
+func TestInBranch(t *testing.T) {
+	source := newSourceCode(platformTestContent)
+
+	index, err := source.findPlacement("x.c", &Options{inBranch: "defined(_WIN32)"})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, index)
+
+	index, err = source.findPlacement("x.c", &Options{inBranch: "else:_WIN32"})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, index)
+
+	index, err = source.findPlacement("x.c", &Options{inBranch: "HAVE_FOO"})
+	assert.Nil(t, err)
+	assert.Equal(t, 9, index)
+
+	_, err = source.findPlacement("x.c", &Options{inBranch: "else:HAVE_FOO"})
+	assert.NotNil(t, err)
+	_, err = source.findPlacement("x.c", &Options{inBranch: "defined(__APPLE__)"})
+	assert.NotNil(t, err)
+}
+
+func TestNormalizeCondition(t *testing.T) {
+	assert.Equal(t, "defined(A)&&!defined(B)", normalizeCondition("defined A && ! defined( B )"))
+}
+
+func TestSkipPreamble(t *testing.T) {
+	testcontent := `#!/usr/bin/tcc -run
+/*
+ * Copyright 2022 Someone
+ * SPDX-License-Identifier: GPL-2.0
+ */
+// -*- mode: c -*-
+
+/** Adds two numbers */
+int add(int a, int b) { return a + b; }
+`
+	source := newSourceCode(testcontent)
+	assert.Equal(t, 6, source.preambleEnd())
+	assert.Equal(t, 6, source.lineAfter(source.findInsertPos()))
+
+	// A doc comment directly above code belongs to the code
+	assert.Equal(t, 0, newSourceCode("/** Adds */\nint add();\n").preambleEnd())
+	assert.Equal(t, 1, newSourceCode("// License\n/// Adds\n/// two numbers\nint add();\n").preambleEnd())
+	// ...but other comments are always skipped
+	assert.Equal(t, 1, newSourceCode("// SPDX-License-Identifier: MIT\nint x;\n").preambleEnd())
+	assert.Equal(t, 2, newSourceCode("/* Copyright */\n/* vim: set ts=4: */\nstatic int x;\n").preambleEnd())
+	assert.Equal(t, 1, newSourceCode("// License\n#ifndef A\n").preambleEnd())
+	assert.Equal(t, 1, newSourceCode("// Only a comment").preambleEnd())
+}
+
+func TestSkipLicenseAboveCode(t *testing.T) {
+	result, err := addIncludeToText("x.c", "// SPDX-License-Identifier: MIT\nint x;\n", "stdio", defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, "// SPDX-License-Identifier: MIT\n\n#include <stdio.h>\n\nint x;\n", result)
+	result, err = addIncludeToText("x.c", "/* Copyright */\n/* vim: set ts=4: */\nstatic int x;\n", "stdio", defaultOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, "/* Copyright */\n/* vim: set ts=4: */\n\n#include <stdio.h>\n\nstatic int x;\n", result)
+}
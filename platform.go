@@ -0,0 +1,171 @@
+package main
+
+import "fmt"
+
+// The condition that is used for selecting Windows specific includes
+const windowsMacro = "_WIN32"
+
+// platformSet is a group of headers that are only available on POSIX systems
+// or only on Windows, where the headers for one platform are the counterparts
+// of the headers for the other platform
+type platformSet struct {
+	name    string
+	posix   []string
+	windows []string
+}
+
+// builtinPlatformSets can be extended with [platform NAME] sections in the
+// configuration file. The first set that has a header is used.
+var builtinPlatformSets = []platformSet{
+	{"windows", nil, []string{"windows.h"}},
+	{"unistd", []string{"unistd.h"}, []string{"io.h", "process.h"}},
+	{"sockets", []string{"sys/socket.h", "netinet/in.h", "arpa/inet.h", "netdb.h"}, []string{"winsock2.h", "ws2tcpip.h"}},
+	{"threads", []string{"pthread.h"}, []string{"windows.h", "process.h"}},
+	{"dlfcn", []string{"dlfcn.h"}, []string{"windows.h"}},
+	{"mman", []string{"sys/mman.h"}, []string{"windows.h"}},
+	{"time", []string{"sys/time.h"}, []string{"winsock2.h"}},
+	{"direct", []string{"sys/stat.h"}, []string{"direct.h"}},
+}
+
+// Find the platform set that has the given header
+func findPlatformSet(sets []platformSet, name string) *platformSet {
+	for i, set := range sets {
+		for _, header := range append(set.posix, set.windows...) {
+			if header == name {
+				return &sets[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Find the conditional that selects between Windows and POSIX, like
+// "#ifdef _WIN32" or "#ifndef _WIN32". Returns the region and the Windows and
+// POSIX branches, where one of the branches may be nil.
+func (tree *condTree) platformRegion() (*condRegion, *condBranch, *condBranch) {
+	var find func(regions []*condRegion) (*condRegion, *condBranch, *condBranch)
+	find = func(regions []*condRegion) (*condRegion, *condBranch, *condBranch) {
+		for _, region := range regions {
+			var (
+				first     = region.branches[0]
+				elseBlock *condBranch
+			)
+			if last := region.lastBranch(); last.directive == "else" {
+				elseBlock = last
+			}
+			switch {
+			case first.matches(windowsMacro):
+				return region, first, elseBlock
+			case first.matches("!defined(" + windowsMacro + ")"):
+				return region, elseBlock, first
+			}
+			for _, branch := range region.branches {
+				if region, windows, posix := find(branch.children); region != nil {
+					return region, windows, posix
+				}
+			}
+		}
+		return nil, nil, nil
+	}
+	return find(tree.regions)
+}
+
+// Add the headers from the platform set to the Windows and POSIX branches of
+// the "#ifdef _WIN32" conditional, which is added if it is missing.
+// Headers that are already included are skipped.
+func addPlatformIncludes(filename, filetext string, set *platformSet, opts *Options) (string, error) {
+	source := newSourceCode(filetext)
+	var windows, posix []string
+	for _, header := range set.windows {
+		if !source.reachable(opts).includes(header) {
+			windows = append(windows, header)
+		}
+	}
+	for _, header := range set.posix {
+		if !source.reachable(opts).includes(header) {
+			posix = append(posix, header)
+		}
+	}
+	if len(windows) == 0 && len(posix) == 0 {
+		return filetext, nil
+	}
+
+	region, _, _ := parseConditionals(source.live(opts).lines).platformRegion()
+	if region == nil {
+		// Add a new conditional
+		var block []string
+		if len(windows) > 0 {
+			block = append(block, ifdef+" "+windowsMacro)
+			for _, header := range windows {
+				block = append(block, incl+" <"+header+">")
+			}
+			if len(posix) > 0 {
+				block = append(block, "#else")
+			}
+		} else {
+			block = append(block, ifndef+" "+windowsMacro)
+		}
+		for _, header := range posix {
+			block = append(block, incl+" <"+header+">")
+		}
+		block = append(block, "#endif")
+		index, err := source.findPlacement(filename, opts)
+		if err != nil {
+			return "", err
+		}
+		return joinLines(insertLines(source.lines, index, block, source.getNewline())), nil
+	}
+
+	// Extend the existing conditional
+	var err error
+	if len(windows) > 0 {
+		if filetext, err = addToPlatformBranch(filename, filetext, windows, true, opts); err != nil {
+			return "", err
+		}
+	}
+	if len(posix) > 0 {
+		if filetext, err = addToPlatformBranch(filename, filetext, posix, false, opts); err != nil {
+			return "", err
+		}
+	}
+	return filetext, nil
+}
+
+// Add includes to the Windows or POSIX branch of the platform conditional.
+// An #else branch is added if it is missing.
+func addToPlatformBranch(filename, filetext string, headers []string, forWindows bool, opts *Options) (string, error) {
+	source := newSourceCode(filetext)
+	region, windows, posix := parseConditionals(source.live(opts).lines).platformRegion()
+	if region == nil {
+		return "", fmt.Errorf("could not find the %s conditional in %s", windowsMacro, filename)
+	}
+	if region.end == -1 {
+		return "", fmt.Errorf("the %s conditional in %s has no #endif", windowsMacro, filename)
+	}
+	branch := posix
+	if forWindows {
+		branch = windows
+	}
+	if branch == nil {
+		// Add the missing #else before the #endif
+		prefix, _, _, _ := parseDirective(source.lines[region.start].text)
+		lines := insertLine(source.lines, region.end, prefix+"else", source.getNewline())
+		return addToPlatformBranch(filename, joinLines(lines), headers, forWindows, opts)
+	}
+	index := source.indexInBranch(branch)
+	prefix := includePrefixNear(source.lines, index)
+	for i := region.start; prefix == "" && i < region.end; i++ {
+		// Use the same style as the includes in the other branch
+		if p, name, _, _ := parseDirective(source.lines[i].text); name == "include" {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		prefix = directivePrefixNear(source.lines, index)
+	}
+	var directives []string
+	for _, header := range headers {
+		directives = append(directives, restyleDirective(incl+" <"+header+">", prefix))
+	}
+	return joinLines(insertLines(source.lines, index, directives, source.getNewline())), nil
+}
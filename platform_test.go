@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewPlatformBlock(t *testing.T) {
+	set := findPlatformSet(builtinPlatformSets, "unistd.h")
+	assert.NotNil(t, set)
+	text, err := addPlatformIncludes("x.c", "#include <stdio.h>\n\nint x;\n", set, &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, `#include <stdio.h>
+#ifdef _WIN32
+#include <io.h>
+#include <process.h>
+#else
+#include <unistd.h>
+#endif
+
+int x;
+`, text)
+	// Nothing is added the second time
+	again, err := addPlatformIncludes("x.c", text, set, &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, text, again)
+}
+
+func TestExtendPlatformBlock(t *testing.T) {
+	testcontent := `#ifndef _WIN32
+#  include <unistd.h>
+#endif
+`
+	set := findPlatformSet(builtinPlatformSets, "unistd.h")
+	text, err := addPlatformIncludes("x.c", testcontent, set, &Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, `#ifndef _WIN32
+#  include <unistd.h>
+#else
+#  include <io.h>
+#  include <process.h>
+#endif
+`, text)
+}
+
+func TestUnterminatedPlatformBlock(t *testing.T) {
+	set := findPlatformSet(builtinPlatformSets, "unistd.h")
+	_, err := addPlatformIncludes("x.c", "#ifdef _WIN32\n#include <io.h>\n", set, &Options{})
+	assert.NotNil(t, err)
+}
+
+func TestPlatformConfig(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, configFilename), []byte("[platform getopt]\nposix = getopt.h\nwindows = wingetopt.h\n"), 0644))
+
+	sets := readConfig(filepath.Join(dir, "main.c")).platformSets
+	set := findPlatformSet(sets, "getopt.h")
+	assert.NotNil(t, set)
+	assert.Equal(t, []string{"wingetopt.h"}, set.windows)
+	assert.NotNil(t, findPlatformSet(sets, "unistd.h"))
+	assert.Nil(t, findPlatformSet(sets, "stdio.h"))
+}
@@ -0,0 +1,65 @@
+package main
+
+import "fmt"
+
+// Remove the include directives for the given header name from the text.
+// Directives in protected regions are not touched. Returns the text and the
+// number of removed directives.
+func removeIncludeFromText(filetext, name string) (string, int) {
+	var (
+		lines     = splitLines(filetext)
+		protected = protectedLines(lines)
+		removed   = 0
+	)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if n, _, ok := parseInclude(lines[i].text); ok && n == name && !protected[i] {
+			lines = removeLine(lines, i)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return filetext, 0
+	}
+	return joinLines(lines), removed
+}
+
+// Move the line at index from to just before the line at index to
+func moveLine(filetext string, from, to int) (string, error) {
+	lines := splitLines(filetext)
+	if from < 0 || from >= len(lines) || to < 0 || to > len(lines) {
+		return "", fmt.Errorf("line %d can not be moved to line %d", from+1, to+1)
+	}
+	finalNewline := lines[len(lines)-1].eol != ""
+	moved := lines[from]
+	if moved.eol == "" {
+		moved.eol = newSourceCode(filetext).getNewline()
+	}
+	lines = append(lines[:from], lines[from+1:]...)
+	if from < to {
+		to--
+	}
+	lines = append(lines[:to], append([]line{moved}, lines[to:]...)...)
+	if !finalNewline {
+		lines[len(lines)-1].eol = ""
+	}
+	return joinLines(lines), nil
+}
+
+// Replace the include directives for the given header name with the given
+// directive, in the same style. Directives in protected regions are not
+// touched. Returns the text and the number of replaced directives.
+func replaceIncludeInText(filetext, name, directive string) (string, int) {
+	var (
+		lines     = splitLines(filetext)
+		protected = protectedLines(lines)
+		replaced  = 0
+	)
+	for i, l := range lines {
+		if n, _, ok := parseInclude(l.text); ok && n == name && !protected[i] {
+			prefix, _, _, _ := parseDirective(l.text)
+			lines[i].text = restyleDirective(directive, prefix)
+			replaced++
+		}
+	}
+	return joinLines(lines), replaced
+}
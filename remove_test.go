@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRemoveInclude(t *testing.T) {
+	text, n := removeIncludeFromText("#include <a.h>\n\n#include <b.h>\n\nint x;\n", "b.h")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "#include <a.h>\n\nint x;\n", text)
+
+	text, n = removeIncludeFromText("#include <a.h>\n#include <b.h>", "b.h")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "#include <a.h>", text)
+
+	text, n = removeIncludeFromText("// addinclude: off\n#include <b.h>\n// addinclude: on\n", "b.h")
+	assert.Equal(t, 0, n)
+	assert.Equal(t, "// addinclude: off\n#include <b.h>\n// addinclude: on\n", text)
+}
+
+func TestMoveLine(t *testing.T) {
+	text, err := moveLine("#include <b.h>\n#include <c.h>\n#include <a.h>", 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "#include <a.h>\n#include <b.h>\n#include <c.h>", text)
+
+	text, err = moveLine("a\nb\nc\n", 0, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "b\na\nc\n", text)
+
+	_, err = moveLine("a\n", 3, 0)
+	assert.NotNil(t, err)
+}
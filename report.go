@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// The actions in a file report
+const (
+	actionInserted = "inserted"
+	actionSkipped  = "skipped"
+	actionRemoved  = "removed"
+)
+
+// fileReport describes what was changed in a file, for --json. Line and Offset
+// are where the include directive is in the changed file, or where it was, if
+// it was removed. Offsets are byte offsets in the file, and lines count from 1.
+type fileReport struct {
+	File    string     `json:"file"`
+	Action  string     `json:"action"`
+	Header  string     `json:"header"`
+	Line    int        `json:"line"`
+	Offset  int        `json:"offset"`
+	Text    string     `json:"text"`
+	Newline string     `json:"newline"`
+	Edits   []textEdit `json:"edits"`
+
+	explanation *explanation // with --explain, where the include was placed
+}
+
+// Return the header of an include directive with delimiters, like <stdio.h>,
+// or the directive itself if it can not be parsed
+func headerOf(directive string) string {
+	name, quoted, ok := parseInclude(directive)
+	switch {
+	case !ok:
+		return strings.TrimSpace(directive)
+	case quoted:
+		return "\"" + name + "\""
+	default:
+		return "<" + name + ">"
+	}
+}
+
+// Find the include directive for the given header name in the given lines.
+// Returns the line index, or -1.
+func findIncludeLine(lines []line, name string) int {
+	for i, l := range lines {
+		if n, _, ok := parseInclude(l.text); ok && n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Describe the changes from the original to the changed text of a file, for
+// the given include directive, which may be empty
+func newFileReport(filename, directive, before, after string, et *encodedText) *fileReport {
+	var (
+		edits  = computeEdits(before, after)
+		report = &fileReport{
+			File:    filename,
+			Action:  actionSkipped,
+			Newline: strings.ToLower(eolName(newSourceCode(after).getNewline())),
+			Edits:   et.fileEdits(before, after, edits),
+		}
+	)
+	if report.Edits == nil {
+		report.Edits = []textEdit{}
+	}
+	name, _, ok := parseInclude(directive)
+	if !ok {
+		if len(edits) > 0 {
+			report.Action = actionInserted
+		}
+		return report
+	}
+	report.Header = headerOf(directive)
+
+	var (
+		beforeLines   = splitLines(before)
+		afterLines    = splitLines(after)
+		beforeOffsets = lineOffsets(beforeLines)
+		afterOffsets  = lineOffsets(afterLines)
+	)
+	// Look for the directive among the inserted lines first, then among the removed lines
+	for _, edit := range edits {
+		first := edit.NewLine - 1
+		if i := findIncludeLine(splitLines(edit.Text), name); i != -1 {
+			report.Action = actionInserted
+			report.Line = first + i + 1
+			l := afterLines[first+i]
+			report.Text = l.text + l.eol
+			if l.eol == "" && first+i > 0 {
+				// The include was added after the last line, which had no newline,
+				// so the inserted text starts with that newline
+				report.Text = afterLines[first+i-1].eol + report.Text
+			} else if l.eol != "" {
+				report.Newline = strings.ToLower(eolName(l.eol))
+			}
+			report.Offset = et.byteOffset(after, afterOffsets[first+i]+len(l.text)+len(l.eol)-len(report.Text))
+			return report
+		}
+	}
+	for _, edit := range edits {
+		first := edit.Line - 1
+		if i := findIncludeLine(splitLines(before[edit.Offset:edit.Offset+edit.Length]), name); i != -1 {
+			l := beforeLines[first+i]
+			report.Action = actionRemoved
+			report.Line = first + i + 1
+			report.Offset = et.byteOffset(before, beforeOffsets[first+i])
+			report.Text = l.text + l.eol
+			return report
+		}
+	}
+	// The header was already included
+	if i := findIncludeLine(afterLines, name); i != -1 {
+		report.Line = i + 1
+		report.Offset = et.byteOffset(after, afterOffsets[i])
+	}
+	return report
+}
+
+// Write the report as a single line of JSON, without escaping < and >
+func (report *fileReport) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(report)
+}
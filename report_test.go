@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFileReport(t *testing.T) {
+	et := &encodedText{encoding: encodingUTF8}
+
+	before := "#include <string.h>\r\n\r\nint x;\r\n"
+	after := "#include <string.h>\r\n#include <stdio.h>\r\n\r\nint x;\r\n"
+	report := newFileReport("a.c", "#include <stdio.h>", before, after, et)
+	assert.Equal(t, actionInserted, report.Action)
+	assert.Equal(t, "<stdio.h>", report.Header)
+	assert.Equal(t, 2, report.Line)
+	assert.Equal(t, 21, report.Offset)
+	assert.Equal(t, "#include <stdio.h>\r\n", report.Text)
+	assert.Equal(t, "crlf", report.Newline)
+	assert.Equal(t, 1, len(report.Edits))
+
+	report = newFileReport("a.c", "#include \"stdio.h\"", after, after, et)
+	assert.Equal(t, actionSkipped, report.Action)
+	assert.Equal(t, "\"stdio.h\"", report.Header)
+	assert.Equal(t, 2, report.Line)
+	assert.Equal(t, "", report.Text)
+	assert.Equal(t, []textEdit{}, report.Edits)
+
+	report = newFileReport("a.c", "#include <stdio.h>", after, before, et)
+	assert.Equal(t, actionRemoved, report.Action)
+	assert.Equal(t, 2, report.Line)
+	assert.Equal(t, 21, report.Offset)
+
+	// The last line had no newline
+	report = newFileReport("b.c", "#include <stdio.h>", "#include <string.h>", "#include <string.h>\n#include <stdio.h>", et)
+	assert.Equal(t, actionInserted, report.Action)
+	assert.Equal(t, "\n#include <stdio.h>", report.Text)
+	assert.Equal(t, 19, report.Offset)
+
+	var buf bytes.Buffer
+	assert.Nil(t, report.writeJSON(&buf))
+	assert.Contains(t, buf.String(), `"header":"<stdio.h>"`)
+}
@@ -0,0 +1,103 @@
+package main
+
+import "strings"
+
+// cSymbols maps identifiers from the C standard library to their headers
+var cSymbols = map[string]string{
+	"printf": "stdio.h", "fprintf": "stdio.h", "sprintf": "stdio.h", "snprintf": "stdio.h",
+	"scanf": "stdio.h", "sscanf": "stdio.h", "puts": "stdio.h", "fputs": "stdio.h",
+	"fopen": "stdio.h", "fclose": "stdio.h", "fread": "stdio.h", "fwrite": "stdio.h",
+	"fgets": "stdio.h", "getchar": "stdio.h", "putchar": "stdio.h", "perror": "stdio.h",
+	"FILE": "stdio.h", "stdin": "stdio.h", "stdout": "stdio.h", "stderr": "stdio.h", "EOF": "stdio.h",
+	"malloc": "stdlib.h", "calloc": "stdlib.h", "realloc": "stdlib.h", "free": "stdlib.h",
+	"exit": "stdlib.h", "abort": "stdlib.h", "atoi": "stdlib.h", "strtol": "stdlib.h",
+	"qsort": "stdlib.h", "getenv": "stdlib.h", "rand": "stdlib.h", "EXIT_SUCCESS": "stdlib.h",
+	"EXIT_FAILURE": "stdlib.h",
+	"strlen":       "string.h", "strcmp": "string.h", "strncmp": "string.h", "strcpy": "string.h",
+	"strncpy": "string.h", "strcat": "string.h", "strchr": "string.h", "strstr": "string.h",
+	"memcpy": "string.h", "memmove": "string.h", "memset": "string.h", "memcmp": "string.h",
+	"isalpha": "ctype.h", "isdigit": "ctype.h", "isspace": "ctype.h", "toupper": "ctype.h",
+	"tolower": "ctype.h",
+	"sqrt":    "math.h", "pow": "math.h", "sin": "math.h", "cos": "math.h", "fabs": "math.h",
+	"floor": "math.h", "ceil": "math.h",
+	"time": "time.h", "clock": "time.h", "time_t": "time.h",
+	"assert": "assert.h", "errno": "errno.h",
+	"bool": "stdbool.h", "true": "stdbool.h", "false": "stdbool.h",
+	"size_t": "stddef.h", "NULL": "stddef.h", "offsetof": "stddef.h",
+	"int8_t": "stdint.h", "int16_t": "stdint.h", "int32_t": "stdint.h", "int64_t": "stdint.h",
+	"uint8_t": "stdint.h", "uint16_t": "stdint.h", "uint32_t": "stdint.h", "uint64_t": "stdint.h",
+	"uintptr_t": "stdint.h",
+	"va_list":   "stdarg.h", "va_start": "stdarg.h", "va_end": "stdarg.h",
+	"INT_MAX": "limits.h", "INT_MIN": "limits.h",
+	"signal": "signal.h", "setjmp": "setjmp.h", "longjmp": "setjmp.h",
+}
+
+// cppSymbols maps identifiers from the C++ standard library to their headers
+var cppSymbols = map[string]string{
+	"std::cout": "iostream", "std::cin": "iostream", "std::cerr": "iostream", "std::endl": "iostream",
+	"std::string": "string", "std::to_string": "string", "std::getline": "string",
+	"std::string_view": "string_view",
+	"std::vector":      "vector", "std::map": "map", "std::set": "set", "std::list": "list",
+	"std::deque": "deque", "std::array": "array", "std::unordered_map": "unordered_map",
+	"std::unordered_set": "unordered_set", "std::pair": "utility", "std::move": "utility",
+	"std::forward": "utility", "std::tuple": "tuple", "std::optional": "optional",
+	"std::variant": "variant", "std::function": "functional",
+	"std::unique_ptr": "memory", "std::shared_ptr": "memory", "std::make_unique": "memory",
+	"std::make_shared": "memory", "std::weak_ptr": "memory",
+	"std::sort": "algorithm", "std::find": "algorithm", "std::min": "algorithm",
+	"std::max": "algorithm", "std::copy": "algorithm", "std::transform": "algorithm",
+	"std::accumulate": "numeric", "std::thread": "thread", "std::mutex": "mutex",
+	"std::lock_guard": "mutex", "std::atomic": "atomic", "std::stringstream": "sstream",
+	"std::ostringstream": "sstream", "std::istringstream": "sstream",
+	"std::ifstream": "fstream", "std::ofstream": "fstream",
+	"std::runtime_error": "stdexcept", "std::exception": "exception",
+	"std::size_t": "cstddef", "std::chrono": "chrono",
+}
+
+// keywords are the C and C++ keywords, which never need an include
+var keywords = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true, "const": true, "continue": true,
+	"default": true, "do": true, "double": true, "else": true, "enum": true, "extern": true,
+	"float": true, "for": true, "goto": true, "if": true, "inline": true, "int": true,
+	"long": true, "register": true, "restrict": true, "return": true, "short": true,
+	"signed": true, "sizeof": true, "static": true, "struct": true, "switch": true,
+	"typedef": true, "union": true, "unsigned": true, "void": true, "volatile": true,
+	"while": true, "class": true, "delete": true, "namespace": true, "new": true,
+	"operator": true, "private": true, "protected": true, "public": true, "template": true,
+	"this": true, "throw": true, "try": true, "catch": true, "typename": true, "using": true,
+	"virtual": true, "nullptr": true, "constexpr": true, "decltype": true, "noexcept": true,
+}
+
+// Find the header for the given identifier, like stdio.h for printf or vector
+// for std::vector. C++ identifiers are only found in C++ mode, and identifiers
+// from the C library are then also found with std::, like std::printf.
+func headerForSymbol(identifier string, cpp bool) (string, bool) {
+	if cpp {
+		if header, ok := cppSymbols[identifier]; ok {
+			return header, true
+		}
+		identifier = strings.TrimPrefix(identifier, "std::")
+	}
+	header, ok := cSymbols[identifier]
+	return header, ok
+}
+
+// Check if the given byte can be part of an identifier, including "::"
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// Return the identifier at the given byte offset in the text, or ""
+func identifierAt(text string, offset int) string {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	start, end := offset, offset
+	for start > 0 && isIdentifierByte(text[start-1]) {
+		start--
+	}
+	for end < len(text) && isIdentifierByte(text[end]) {
+		end++
+	}
+	return strings.Trim(text[start:end], ":")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Replace directories in the given list of paths with the C and C++ source
+// and header files in them, recursively. Hidden directories are skipped.
+func expandPaths(paths []string) []string {
+	var filenames []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.IsDir() {
+			filenames = append(filenames, path)
+			continue
+		}
+		var found []string
+		filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if p != path && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if isSourceFile(p) || hasExtension(p, headerExtensions) {
+				found = append(found, p)
+			}
+			return nil
+		})
+		sort.Strings(found)
+		filenames = append(filenames, found...)
+	}
+	return filenames
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.c", "a.h", "README.md", filepath.Join("sub", "c.cpp"), filepath.Join(".hidden", "d.c")} {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, os.WriteFile(path, []byte{}, 0644))
+	}
+	assert.Equal(t, []string{
+		"x.c",
+		filepath.Join(dir, "a.h"),
+		filepath.Join(dir, "b.c"),
+		filepath.Join(dir, "sub", "c.cpp"),
+	}, expandPaths([]string{"x.c", dir}))
+}